@@ -10,11 +10,19 @@ import (
 	"os"
 	"os/signal"
 	"supmap-navigation/internal/api"
+	"supmap-navigation/internal/auth"
 	"supmap-navigation/internal/cache"
+	"supmap-navigation/internal/cluster"
 	"supmap-navigation/internal/config"
+	"supmap-navigation/internal/congestion"
+	"supmap-navigation/internal/gis"
 	routing "supmap-navigation/internal/gis/routing"
 	"supmap-navigation/internal/incidents"
+	"supmap-navigation/internal/reports"
+	"supmap-navigation/internal/reroute"
 	"supmap-navigation/internal/subscriber"
+	"supmap-navigation/internal/tracing"
+	"supmap-navigation/internal/tracking"
 	"supmap-navigation/internal/ws"
 	"syscall"
 	"time"
@@ -43,27 +51,111 @@ func run() error {
 	jsonHandler := slog.NewJSONHandler(os.Stdout, &loggerOpts)
 	logger := slog.New(jsonHandler)
 
-	redisClient := redis.NewClient(&redis.Options{Addr: net.JoinHostPort(conf.RedisHost, conf.RedisPort)})
-	sessionCache := cache.NewRedisSessionCache(redisClient, 30*time.Minute)
+	shutdownTracing, err := tracing.Setup(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Warn("failed to flush traces", "error", err)
+		}
+	}()
 
-	wsManager := ws.NewManager(ctx, logger, sessionCache)
+	redisClient := redis.NewClient(&redis.Options{Addr: net.JoinHostPort(conf.RedisHost, conf.RedisPort)})
+	sessionTTL := 30 * time.Minute
+	sessionCache := cache.NewRedisSessionCache(redisClient, sessionTTL)
+	outbox := cache.NewRedisOutbox(redisClient, sessionTTL, conf.OutboxSize)
+	breadcrumbs := cache.NewRedisBreadcrumbStore(redisClient, conf.BreadcrumbsTTL, conf.BreadcrumbsSize)
+	trafficStore := cache.NewRedisTrafficStore(redisClient, conf.TrafficBucket, conf.TrafficRetention)
+	// The incidents of a trip are kept for the arrival summary, even on long trips.
+	tripIncidents := cache.NewRedisTripIncidentStore(redisClient, 24*time.Hour)
 
 	supmapGISURL := fmt.Sprintf("http://%s:%s", conf.SupmapGISHost, conf.SupmapGISPort)
 	routingClient := routing.NewClient(supmapGISURL)
 	logger.Info("supmap-gis client initialized", "url", supmapGISURL)
 
-	multicaster := incidents.NewMulticaster(wsManager, sessionCache, routingClient)
-	sub := subscriber.NewSubscriber(conf, logger, redisClient, conf.RedisIncidentsChannel, 10, multicaster)
+	routesIndex := gis.NewGridIndex(conf.RoutesIndexCellSize)
+	nodes := cluster.NewCluster(redisClient, logger, conf.InstanceID, conf.PresenceHeartbeat)
+	wsManager := ws.NewManager(ctx, logger, sessionCache, outbox, routesIndex, nodes)
+
+	rerouter := reroute.NewRerouter(conf, logger, wsManager, routingClient, sessionCache)
+	var detector *congestion.Detector
+	if conf.CongestionIncidentsChannel != "" {
+		detector = congestion.NewDetector(conf, logger, redisClient)
+	} else {
+		logger.Info("congestion detection is disabled (CONGESTION_INCIDENTS_CHANNEL is empty)")
+	}
+	tracker := tracking.NewTracker(conf, logger, rerouter, breadcrumbs, trafficStore, detector, tripIncidents)
+	wsManager.HandlePositions(tracker)
+	wsManager.HandleMessage("route_choice", rerouter.HandleRouteChoice)
+
+	supmapIncidentsURL := fmt.Sprintf("http://%s:%s", conf.SupmapIncidentsHost, conf.SupmapIncidentsPort)
+	var forwarder reports.Forwarder
+	switch {
+	case conf.ReportsForwarder == config.ReportsForwarderMemory:
+		forwarder = reports.NewMemoryForwarder()
+	case conf.SupmapIncidentsHost != "":
+		forwarder = reports.NewHTTPForwarder(supmapIncidentsURL, conf.SupmapIncidentsToken, 5*time.Second)
+		logger.Info("supmap-incidents client initialized", "url", supmapIncidentsURL)
+	default:
+		logger.Info("incident reports are disabled (SUPMAP_INCIDENTS_HOST is empty)")
+	}
+	reportsHandler := reports.NewHandler(conf, logger, sessionCache, forwarder, cache.NewRedisRateLimiter(redisClient))
+	wsManager.HandleMessage("report_incident", reportsHandler.HandleReport)
+	wsManager.HandleMessage("vote_incident", reportsHandler.HandleVote)
+
+	incidentsStore := incidents.NewStore()
+	multicaster := incidents.NewMulticaster(conf, wsManager, sessionCache, rerouter, incidentsStore, tripIncidents)
+	wsManager.HandleRoutes(multicaster)
+	deadLetters := cache.NewRedisDeadLetterStore(redisClient, conf.DeadLettersSize)
+	var sub subscriber.IncidentSource
+	switch conf.IncidentsSource {
+	case config.IncidentsSourceStream:
+		sub = subscriber.NewStreamSubscriber(conf, logger, redisClient, conf.RedisIncidentsStream, 10, multicaster, deadLetters)
+	case config.IncidentsSourceMemory:
+		sub = subscriber.NewMemorySource(logger, 100, 10, multicaster, deadLetters)
+	default:
+		sub = subscriber.NewSubscriber(conf, logger, redisClient, conf.RedisIncidentsChannel, 10, multicaster, deadLetters)
+	}
 
 	go wsManager.Start()
+	go multicaster.ExpireIncidents(ctx)
+
+	go func() {
+		if err := nodes.Start(ctx, wsManager); err != nil {
+			logger.Error("cluster stopped with error", "error", err)
+		}
+	}()
 
 	go func() {
 		if err := sub.Start(ctx); err != nil {
-			logger.Error("subscriber stopped with error: ", err)
+			logger.Error("subscriber stopped with error", "error", err)
 		}
 	}()
 
-	server := api.NewServer(conf, wsManager, logger)
+	// The subscriber is started first so that no incident is missed while bootstrapping,
+	// the incidents it received are not replaced by the fetched ones.
+	if conf.IncidentsBootstrap {
+		active, err := incidents.NewClient(supmapIncidentsURL, conf.SupmapIncidentsToken, 10*time.Second).ListActive(ctx)
+		if err != nil {
+			logger.Warn("failed to bootstrap active incidents", "error", err)
+		} else {
+			incidentsStore.Load(active)
+			logger.Info("active incidents bootstrapped", "count", len(active))
+		}
+	}
+
+	authenticator, err := auth.NewAuthenticator(conf)
+	if err != nil {
+		return err
+	}
+	if authenticator == nil {
+		logger.Warn("authentication is disabled, any client can connect to any session")
+	}
+
+	server := api.NewServer(conf, wsManager, authenticator, deadLetters, sub, breadcrumbs, trafficStore, logger)
 	if err := server.Start(ctx); err != nil {
 		return err
 	}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/matheodrd/httphelper/handler"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"supmap-navigation/internal/subscriber"
+)
+
+// defaultDeadLettersCount is the number of dead letters listed when the request doesn't specify it.
+const defaultDeadLettersCount = 50
+
+// adminOnly rejects the requests without the admin token in their Authorization header.
+func (s *Server) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return handler.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.Config.AdminToken)) != 1 {
+			return handler.NewErrWithStatus(http.StatusUnauthorized, errors.New("invalid admin token"))
+		}
+		next(w, r)
+		return nil
+	})
+}
+
+func (s *Server) listDeadLetters() http.HandlerFunc {
+	return handler.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		count := int64(defaultDeadLettersCount)
+		if raw := r.URL.Query().Get("count"); raw != "" {
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || n < 1 {
+				return handler.NewErrWithStatus(http.StatusBadRequest, errors.New("count must be a positive integer"))
+			}
+			count = n
+		}
+
+		letters, err := s.DeadLetters.List(r.Context(), count)
+		if err != nil {
+			return err
+		}
+		return handler.Encode(handler.Response[[]subscriber.DeadLetter]{Data: &letters}, http.StatusOK, w)
+	})
+}
+
+func (s *Server) getDeadLetter() http.HandlerFunc {
+	return handler.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		letter, err := s.DeadLetters.Get(r.Context(), r.PathValue("id"))
+		if err != nil {
+			return deadLetterError(err)
+		}
+		return handler.Encode(handler.Response[subscriber.DeadLetter]{Data: letter}, http.StatusOK, w)
+	})
+}
+
+func (s *Server) deleteDeadLetter() http.HandlerFunc {
+	return handler.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		if err := s.DeadLetters.Delete(r.Context(), r.PathValue("id")); err != nil {
+			return deadLetterError(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+// reinjectDeadLetter publishes a dead letter again to the incidents source and removes it from the store.
+// A non-empty request body replaces the original payload, to re-inject a fixed message.
+func (s *Server) reinjectDeadLetter() http.HandlerFunc {
+	return handler.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		letter, err := s.DeadLetters.Get(r.Context(), r.PathValue("id"))
+		if err != nil {
+			return deadLetterError(err)
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return handler.NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", err))
+		}
+		payload := letter.Payload
+		if len(body) > 0 {
+			payload = string(body)
+		}
+
+		if err := s.Incidents.Publish(r.Context(), payload); err != nil {
+			return fmt.Errorf("failed to reinject dead letter: %w", err)
+		}
+		if err := s.DeadLetters.Delete(r.Context(), letter.ID); err != nil {
+			return deadLetterError(err)
+		}
+		return handler.Encode(handler.Response[struct{}]{Message: "dead letter reinjected"}, http.StatusOK, w)
+	})
+}
+
+// publishIncident publishes the incident message of the request body to the incidents source,
+// as supmap-incidents would. It is mostly useful with the in-memory source.
+func (s *Server) publishIncident() http.HandlerFunc {
+	return handler.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return handler.NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", err))
+		}
+		if len(body) == 0 {
+			return handler.NewErrWithStatus(http.StatusBadRequest, errors.New("missing incident message"))
+		}
+
+		if err := s.Incidents.Publish(r.Context(), string(body)); err != nil {
+			return fmt.Errorf("failed to publish incident: %w", err)
+		}
+		return handler.Encode(handler.Response[struct{}]{Message: "incident published"}, http.StatusAccepted, w)
+	})
+}
+
+func deadLetterError(err error) error {
+	if errors.Is(err, subscriber.ErrDeadLetterNotFound) {
+		return handler.NewErrWithStatus(http.StatusNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/matheodrd/httphelper/handler"
+	"net/http"
+	"supmap-navigation/internal/navigation"
+	"time"
+)
+
+// gpx is the GPX 1.1 document of a trip, with a single track made of the breadcrumbs.
+type gpx struct {
+	XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string       `xml:"name"`
+	Segment []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time"`
+}
+
+// geoJSONFeature is a GeoJSON Feature holding the breadcrumbs as a LineString.
+// The timestamps of the points are in the "timestamps" property, in the same order as the coordinates.
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONLineString `json:"geometry"`
+	Properties map[string]any    `json:"properties"`
+}
+
+type geoJSONLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+// getBreadcrumbs returns the positions recorded for a session, as JSON (default), GPX or GeoJSON
+// depending on the "format" query parameter.
+func (s *Server) getBreadcrumbs() http.HandlerFunc {
+	return handler.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		sessionID := r.PathValue("id")
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "gpx" && format != "geojson" {
+			return handler.NewErrWithStatus(http.StatusBadRequest, errors.New("format must be json, gpx or geojson"))
+		}
+
+		positions, err := s.Breadcrumbs.List(r.Context(), sessionID)
+		if err != nil {
+			return err
+		}
+		if len(positions) == 0 {
+			return handler.NewErrWithStatus(http.StatusNotFound, fmt.Errorf("no breadcrumbs for session %s", sessionID))
+		}
+
+		switch format {
+		case "gpx":
+			w.Header().Set("Content-Type", "application/gpx+xml")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sessionID+".gpx"))
+			return encodeGPX(w, sessionID, positions)
+		case "geojson":
+			w.Header().Set("Content-Type", "application/geo+json")
+			return json.NewEncoder(w).Encode(newGeoJSONFeature(sessionID, positions))
+		default:
+			return handler.Encode(handler.Response[[]navigation.Position]{Data: &positions}, http.StatusOK, w)
+		}
+	})
+}
+
+func encodeGPX(w http.ResponseWriter, sessionID string, positions []navigation.Position) error {
+	points := make([]gpxPoint, len(positions))
+	for i, p := range positions {
+		points[i] = gpxPoint{Lat: p.Lat, Lon: p.Lon, Time: p.Timestamp.UTC().Format(time.RFC3339)}
+	}
+	doc := gpx{
+		Version: "1.1",
+		Creator: "supmap-navigation",
+		Track: gpxTrack{
+			Name:    sessionID,
+			Segment: []gpxSegment{{Points: points}},
+		},
+	}
+
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+// newGeoJSONFeature builds the LineString of the positions. GeoJSON coordinates are in [lon, lat] order.
+func newGeoJSONFeature(sessionID string, positions []navigation.Position) geoJSONFeature {
+	coordinates := make([][2]float64, len(positions))
+	timestamps := make([]time.Time, len(positions))
+	for i, p := range positions {
+		coordinates[i] = [2]float64{p.Lon, p.Lat}
+		timestamps[i] = p.Timestamp
+	}
+	return geoJSONFeature{
+		Type:     "Feature",
+		Geometry: geoJSONLineString{Type: "LineString", Coordinates: coordinates},
+		Properties: map[string]any{
+			"session_id": sessionID,
+			"timestamps": timestamps,
+		},
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/coder/websocket"
 	"github.com/matheodrd/httphelper/handler"
 	"net/http"
+	"supmap-navigation/internal/auth"
+	"supmap-navigation/internal/ws"
 )
 
 func (s *Server) wsHandler() http.HandlerFunc {
@@ -15,12 +17,30 @@ func (s *Server) wsHandler() http.HandlerFunc {
 			return handler.NewErrWithStatus(http.StatusBadRequest, errors.New("missing session_id"))
 		}
 
-		conn, err := websocket.Accept(w, r, nil)
+		var userID string
+		if s.Authenticator != nil {
+			token, err := auth.TokenFromRequest(r)
+			if err != nil {
+				return handler.NewErrWithStatus(http.StatusUnauthorized, err)
+			}
+			userID, err = s.Authenticator.Authenticate(token)
+			if err != nil {
+				return handler.NewErrWithStatus(http.StatusUnauthorized, err)
+			}
+		}
+		if err := s.WebsocketManager.Authorize(r.Context(), sessionID, userID); err != nil {
+			if errors.Is(err, ws.ErrSessionForbidden) {
+				return handler.NewErrWithStatus(http.StatusForbidden, err)
+			}
+			return fmt.Errorf("authorizing session: %w", err)
+		}
+
+		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: []string{auth.Subprotocol}})
 		if err != nil {
 			return handler.NewErrWithStatus(http.StatusInternalServerError, fmt.Errorf("websocket accept: %w", err))
 		}
 
-		s.WebsocketManager.HandleNewConnection(sessionID, conn)
+		s.WebsocketManager.HandleNewConnection(sessionID, userID, conn)
 		return nil
 	})
 }
@@ -3,10 +3,15 @@ package api
 import (
 	"context"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"log/slog"
 	"net"
 	"net/http"
+	"supmap-navigation/internal/auth"
 	"supmap-navigation/internal/config"
+	"supmap-navigation/internal/navigation"
+	"supmap-navigation/internal/subscriber"
+	"supmap-navigation/internal/traffic"
 	"supmap-navigation/internal/ws"
 	"sync"
 	"time"
@@ -15,14 +20,25 @@ import (
 type Server struct {
 	Config           *config.Config
 	WebsocketManager *ws.Manager
-	logger           *slog.Logger
+	// Authenticator validates the tokens of the WebSocket connections, nil if authentication is disabled.
+	Authenticator *auth.Authenticator
+	DeadLetters   subscriber.DeadLetterStore
+	Incidents     subscriber.IncidentSource
+	Breadcrumbs   navigation.BreadcrumbStore
+	Traffic       traffic.Store
+	logger        *slog.Logger
 }
 
-func NewServer(config *config.Config, websocketManager *ws.Manager, logger *slog.Logger) *Server {
+func NewServer(config *config.Config, websocketManager *ws.Manager, authenticator *auth.Authenticator, deadLetters subscriber.DeadLetterStore, incidents subscriber.IncidentSource, breadcrumbs navigation.BreadcrumbStore, traffic traffic.Store, logger *slog.Logger) *Server {
 	return &Server{
 		Config:           config,
 		logger:           logger,
 		WebsocketManager: websocketManager,
+		Authenticator:    authenticator,
+		DeadLetters:      deadLetters,
+		Incidents:        incidents,
+		Breadcrumbs:      breadcrumbs,
+		Traffic:          traffic,
 	}
 }
 
@@ -37,7 +53,18 @@ func (s *Server) health(w http.ResponseWriter, _ *http.Request) {
 func (s *Server) Start(ctx context.Context) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /health", s.health)
+	mux.Handle("GET /metrics", promhttp.Handler())
 	mux.HandleFunc("/ws", s.wsHandler())
+	mux.HandleFunc("GET /traffic/segments", s.getTrafficSegments())
+	// The admin endpoints are only exposed when an admin token is configured.
+	if s.Config.AdminToken != "" {
+		mux.HandleFunc("GET /admin/dead-letters", s.adminOnly(s.listDeadLetters()))
+		mux.HandleFunc("GET /admin/dead-letters/{id}", s.adminOnly(s.getDeadLetter()))
+		mux.HandleFunc("DELETE /admin/dead-letters/{id}", s.adminOnly(s.deleteDeadLetter()))
+		mux.HandleFunc("POST /admin/dead-letters/{id}/reinject", s.adminOnly(s.reinjectDeadLetter()))
+		mux.HandleFunc("POST /admin/incidents", s.adminOnly(s.publishIncident()))
+		mux.HandleFunc("GET /admin/sessions/{id}/breadcrumbs", s.adminOnly(s.getBreadcrumbs()))
+	}
 
 	server := &http.Server{
 		Addr:    net.JoinHostPort(s.Config.APIServerHost, s.Config.APIServerPort),
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"github.com/matheodrd/httphelper/handler"
+	"net/http"
+	"strconv"
+	"supmap-navigation/internal/gis"
+	"supmap-navigation/internal/traffic"
+	"time"
+)
+
+const (
+	defaultTrafficRadius = 1000
+	maxTrafficRadius     = 5000
+	defaultTrafficWindow = 15 * time.Minute
+)
+
+// getTrafficSegments returns the observed speeds and congestion of the road segments around a point,
+// aggregated over the last "window" (15 minutes by default).
+func (s *Server) getTrafficSegments() http.HandlerFunc {
+	return handler.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		query := r.URL.Query()
+
+		lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+		if err != nil || lat < -90 || lat > 90 {
+			return handler.NewErrWithStatus(http.StatusBadRequest, errors.New("lat must be a latitude"))
+		}
+		lon, err := strconv.ParseFloat(query.Get("lon"), 64)
+		if err != nil || lon < -180 || lon > 180 {
+			return handler.NewErrWithStatus(http.StatusBadRequest, errors.New("lon must be a longitude"))
+		}
+
+		radius := float64(defaultTrafficRadius)
+		if raw := query.Get("radius"); raw != "" {
+			radius, err = strconv.ParseFloat(raw, 64)
+			if err != nil || radius <= 0 || radius > maxTrafficRadius {
+				return handler.NewErrWithStatus(http.StatusBadRequest, errors.New("radius must be a positive number of metres, at most 5000"))
+			}
+		}
+
+		window := defaultTrafficWindow
+		if raw := query.Get("window"); raw != "" {
+			window, err = time.ParseDuration(raw)
+			if err != nil || window <= 0 || window > s.Config.TrafficRetention {
+				return handler.NewErrWithStatus(http.StatusBadRequest, errors.New("window must be a positive duration, at most TRAFFIC_RETENTION"))
+			}
+		}
+
+		now := time.Now()
+		stats, err := s.Traffic.Query(r.Context(), gis.Point{Lat: lat, Lon: lon}, radius, now.Add(-window), now)
+		if err != nil {
+			return err
+		}
+		segments := traffic.Merge(stats, s.Config.TrafficMinSamples)
+		return handler.Encode(handler.Response[[]traffic.SegmentStats]{Data: &segments}, http.StatusOK, w)
+	})
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"os"
+	"strings"
+	"supmap-navigation/internal/config"
+)
+
+// Subprotocol is the WebSocket subprotocol selected by the server.
+// Browsers can't set the Authorization header of a WebSocket handshake, so they pass the token as a
+// "bearer.<token>" subprotocol, next to this one which the server echoes back.
+const Subprotocol = "supmap-navigation"
+
+const subprotocolTokenPrefix = "bearer."
+
+var ErrMissingToken = errors.New("missing bearer token")
+
+// Authenticator validates the JWTs of the clients, signed either by a key of a JWKS or with a shared secret.
+type Authenticator struct {
+	keyfunc jwt.Keyfunc
+	parser  *jwt.Parser
+}
+
+// NewAuthenticator creates the authenticator configured by AUTH_JWKS_FILE or AUTH_JWT_SECRET.
+// It returns nil if authentication is disabled, which the config only allows in dev.
+func NewAuthenticator(conf *config.Config) (*Authenticator, error) {
+	opts := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if conf.AuthIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(conf.AuthIssuer))
+	}
+	if conf.AuthAudience != "" {
+		opts = append(opts, jwt.WithAudience(conf.AuthAudience))
+	}
+
+	switch {
+	case conf.AuthJWKSFile != "":
+		raw, err := os.ReadFile(conf.AuthJWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+		}
+		jwks, err := keyfunc.NewJWKSetJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWKS file: %w", err)
+		}
+		return &Authenticator{keyfunc: jwks.Keyfunc, parser: jwt.NewParser(opts...)}, nil
+	case conf.AuthJWTSecret != "":
+		secret := []byte(conf.AuthJWTSecret)
+		opts = append(opts, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+		return &Authenticator{
+			keyfunc: func(*jwt.Token) (any, error) { return secret, nil },
+			parser:  jwt.NewParser(opts...),
+		}, nil
+	}
+	return nil, nil
+}
+
+// Authenticate validates the token and returns the ID of its user, the "sub" claim.
+func (a *Authenticator) Authenticate(token string) (string, error) {
+	var claims jwt.RegisteredClaims
+	if _, err := a.parser.ParseWithClaims(token, &claims, a.keyfunc); err != nil {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+	if claims.Subject == "" {
+		return "", errors.New("invalid token: missing subject")
+	}
+	return claims.Subject, nil
+}
+
+// TokenFromRequest returns the bearer token of a WebSocket handshake, looked up in the Authorization header,
+// then in the access_token query parameter, then in the Sec-WebSocket-Protocol header.
+func TokenFromRequest(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || token == "" {
+			return "", errors.New("malformed Authorization header")
+		}
+		return token, nil
+	}
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token, nil
+	}
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if token, ok := strings.CutPrefix(strings.TrimSpace(protocol), subprotocolTokenPrefix); ok && token != "" {
+				return token, nil
+			}
+		}
+	}
+	return "", ErrMissingToken
+}
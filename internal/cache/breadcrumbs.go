@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"supmap-navigation/internal/navigation"
+	"time"
+)
+
+// RedisBreadcrumbStore stores the positions of each session in a capped Redis list.
+// The list outlives the session, its TTL is refreshed on every position.
+type RedisBreadcrumbStore struct {
+	client *redis.Client
+	ttl    time.Duration
+	size   int64
+}
+
+func NewRedisBreadcrumbStore(client *redis.Client, ttl time.Duration, size int64) *RedisBreadcrumbStore {
+	return &RedisBreadcrumbStore{client: client, ttl: ttl, size: size}
+}
+
+func (r RedisBreadcrumbStore) Append(ctx context.Context, sessionID string, position navigation.Position) error {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return fmt.Errorf("marshalling breadcrumb: %w", err)
+	}
+
+	key := formatBreadcrumbsKey(sessionID)
+	pipe := r.client.TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, -r.size, -1)
+	pipe.Expire(ctx, key, r.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("storing breadcrumb: %w", err)
+	}
+	return nil
+}
+
+func (r RedisBreadcrumbStore) List(ctx context.Context, sessionID string) ([]navigation.Position, error) {
+	values, err := r.client.LRange(ctx, formatBreadcrumbsKey(sessionID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting breadcrumbs: %w", err)
+	}
+
+	positions := make([]navigation.Position, len(values))
+	for i, val := range values {
+		if err := json.Unmarshal([]byte(val), &positions[i]); err != nil {
+			return nil, fmt.Errorf("unmarshalling breadcrumb: %w", err)
+		}
+	}
+	return positions, nil
+}
+
+func formatBreadcrumbsKey(sessionID string) string {
+	return fmt.Sprintf("navigation:breadcrumbs:%s", sessionID)
+}
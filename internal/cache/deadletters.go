@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"supmap-navigation/internal/subscriber"
+	"time"
+)
+
+const deadLettersKey = "navigation:dead-letters"
+
+// RedisDeadLetterStore stores the rejected incident messages in a capped Redis stream.
+type RedisDeadLetterStore struct {
+	client *redis.Client
+	size   int64
+}
+
+func NewRedisDeadLetterStore(client *redis.Client, size int64) *RedisDeadLetterStore {
+	return &RedisDeadLetterStore{client: client, size: size}
+}
+
+func (r RedisDeadLetterStore) Add(ctx context.Context, letter subscriber.DeadLetter) error {
+	err := r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: deadLettersKey,
+		MaxLen: r.size,
+		Approx: true,
+		Values: map[string]any{
+			"source":      letter.Source,
+			"payload":     letter.Payload,
+			"reason":      letter.Reason,
+			"rejected_at": letter.RejectedAt.Format(time.RFC3339Nano),
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("adding dead letter: %w", err)
+	}
+	return nil
+}
+
+func (r RedisDeadLetterStore) List(ctx context.Context, count int64) ([]subscriber.DeadLetter, error) {
+	entries, err := r.client.XRevRangeN(ctx, deadLettersKey, "+", "-", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("listing dead letters: %w", err)
+	}
+
+	letters := make([]subscriber.DeadLetter, len(entries))
+	for i, entry := range entries {
+		letters[i] = parseDeadLetter(entry)
+	}
+	return letters, nil
+}
+
+func (r RedisDeadLetterStore) Get(ctx context.Context, id string) (*subscriber.DeadLetter, error) {
+	entries, err := r.client.XRange(ctx, deadLettersKey, id, id).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting dead letter: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, subscriber.ErrDeadLetterNotFound
+	}
+
+	letter := parseDeadLetter(entries[0])
+	return &letter, nil
+}
+
+func (r RedisDeadLetterStore) Delete(ctx context.Context, id string) error {
+	deleted, err := r.client.XDel(ctx, deadLettersKey, id).Result()
+	if err != nil {
+		return fmt.Errorf("deleting dead letter: %w", err)
+	}
+	if deleted == 0 {
+		return subscriber.ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func parseDeadLetter(entry redis.XMessage) subscriber.DeadLetter {
+	letter := subscriber.DeadLetter{ID: entry.ID}
+	letter.Source, _ = entry.Values["source"].(string)
+	letter.Payload, _ = entry.Values["payload"].(string)
+	letter.Reason, _ = entry.Values["reason"].(string)
+	if rejectedAt, ok := entry.Values["rejected_at"].(string); ok {
+		letter.RejectedAt, _ = time.Parse(time.RFC3339Nano, rejectedAt)
+	}
+	return letter
+}
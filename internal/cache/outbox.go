@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"supmap-navigation/internal/ws"
+	"time"
+)
+
+// appendScript numbers the message with the next sequence number of the session and pushes it in a single step,
+// so that the messages sent concurrently to a session are stored in the order of their sequence numbers.
+// The sequence number is appended to the marshalled message, which is a JSON object.
+var appendScript = redis.NewScript(`
+local seq = redis.call("INCR", KEYS[2])
+redis.call("RPUSH", KEYS[1], string.sub(ARGV[1], 1, -2) .. ',"seq":' .. seq .. '}')
+redis.call("LTRIM", KEYS[1], -tonumber(ARGV[2]), -1)
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+redis.call("EXPIRE", KEYS[2], ARGV[3])
+return seq
+`)
+
+// RedisOutbox stores the last messages sent to each session in a capped Redis list,
+// next to the session itself and with the same TTL.
+type RedisOutbox struct {
+	client *redis.Client
+	ttl    time.Duration
+	size   int64
+}
+
+func NewRedisOutbox(client *redis.Client, ttl time.Duration, size int64) *RedisOutbox {
+	return &RedisOutbox{client: client, ttl: ttl, size: size}
+}
+
+func (r RedisOutbox) Append(ctx context.Context, sessionID string, msg ws.Message) (ws.Message, error) {
+	// The sequence number is added by the script, the message is marshalled without it.
+	msg.Seq = 0
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return msg, fmt.Errorf("marshalling outbox message: %w", err)
+	}
+
+	keys := []string{formatOutboxKey(sessionID), formatOutboxSeqKey(sessionID)}
+	seq, err := appendScript.Run(ctx, r.client, keys, data, r.size, int64(r.ttl.Seconds())).Uint64()
+	if err != nil {
+		return msg, fmt.Errorf("storing outbox message: %w", err)
+	}
+	msg.Seq = seq
+	return msg, nil
+}
+
+func (r RedisOutbox) Since(ctx context.Context, sessionID string, seq uint64) ([]ws.Message, error) {
+	values, err := r.client.LRange(ctx, formatOutboxKey(sessionID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting outbox messages: %w", err)
+	}
+
+	var messages []ws.Message
+	for _, val := range values {
+		var msg ws.Message
+		if err := json.Unmarshal([]byte(val), &msg); err != nil {
+			return nil, fmt.Errorf("unmarshalling outbox message: %w", err)
+		}
+		if msg.Seq > seq {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func formatOutboxKey(sessionID string) string {
+	return fmt.Sprintf("navigation:outbox:%s", sessionID)
+}
+
+func formatOutboxSeqKey(sessionID string) string {
+	return fmt.Sprintf("navigation:outbox:%s:seq", sessionID)
+}
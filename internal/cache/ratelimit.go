@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// RedisRateLimiter counts the actions per key in Redis over fixed windows, shared by the instances.
+type RedisRateLimiter struct {
+	client *redis.Client
+}
+
+func NewRedisRateLimiter(client *redis.Client) *RedisRateLimiter {
+	return &RedisRateLimiter{client: client}
+}
+
+func (r RedisRateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	windowStart := time.Now().Truncate(window)
+	redisKey := fmt.Sprintf("navigation:ratelimit:%s:%d", key, windowStart.Unix())
+
+	pipe := r.client.TxPipeline()
+	count := pipe.Incr(ctx, redisKey)
+	pipe.Expire(ctx, redisKey, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("counting rate limited action: %w", err)
+	}
+	return count.Val() <= int64(limit), nil
+}
@@ -3,12 +3,19 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"supmap-navigation/internal/metrics"
 	"supmap-navigation/internal/navigation"
+	"supmap-navigation/internal/tracing"
 	"time"
 )
 
+var tracer = tracing.Tracer("supmap-navigation/internal/cache")
+
 type RedisSessionCache struct {
 	client *redis.Client
 	ttl    time.Duration
@@ -19,30 +26,52 @@ func NewRedisSessionCache(client *redis.Client, ttl time.Duration) *RedisSession
 }
 
 func (r RedisSessionCache) SetSession(ctx context.Context, session *navigation.Session) error {
+	ctx, span := tracer.Start(ctx, "cache.SetSession", trace.WithAttributes(attribute.String("session.id", session.ID)))
+	defer span.End()
+
 	data, err := json.Marshal(session)
 	if err != nil {
 		return fmt.Errorf("marshalling session: %w", err)
 	}
 	key := formatKey(session.ID)
-	return r.client.Set(ctx, key, data, r.ttl).Err()
+	if err := r.client.Set(ctx, key, data, r.ttl).Err(); err != nil {
+		metrics.CacheErrors.WithLabelValues("set").Inc()
+		tracing.Fail(span, err)
+		return fmt.Errorf("setting session: %w", err)
+	}
+	return nil
 }
 
 func (r RedisSessionCache) GetSession(ctx context.Context, sessionID string) (*navigation.Session, error) {
+	ctx, span := tracer.Start(ctx, "cache.GetSession", trace.WithAttributes(attribute.String("session.id", sessionID)))
+	defer span.End()
+
 	key := formatKey(sessionID)
 	val, err := r.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, navigation.ErrSessionNotFound
+	}
 	if err != nil {
+		metrics.CacheErrors.WithLabelValues("get").Inc()
+		tracing.Fail(span, err)
 		return nil, fmt.Errorf("getting session: %w", err)
 	}
 	var session navigation.Session
 	if err := json.Unmarshal([]byte(val), &session); err != nil {
+		tracing.Fail(span, err)
 		return nil, fmt.Errorf("unmarshalling session: %w", err)
 	}
 	return &session, nil
 }
 
 func (r RedisSessionCache) DeleteSession(ctx context.Context, sessionID string) error {
+	ctx, span := tracer.Start(ctx, "cache.DeleteSession", trace.WithAttributes(attribute.String("session.id", sessionID)))
+	defer span.End()
+
 	key := formatKey(sessionID)
 	if err := r.client.Del(ctx, key).Err(); err != nil {
+		metrics.CacheErrors.WithLabelValues("delete").Inc()
+		tracing.Fail(span, err)
 		return fmt.Errorf("deleting session: %w", err)
 	}
 	return nil
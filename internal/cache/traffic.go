@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"supmap-navigation/internal/gis"
+	"supmap-navigation/internal/traffic"
+	"time"
+)
+
+// RedisTrafficStore aggregates the traffic observations per segment and time bucket in Redis hashes.
+// Each bucket also has a geospatial index of its segments (by midpoint) to query them by area.
+// The keys of a bucket expire after retention.
+type RedisTrafficStore struct {
+	client    *redis.Client
+	bucket    time.Duration
+	retention time.Duration
+}
+
+func NewRedisTrafficStore(client *redis.Client, bucket time.Duration, retention time.Duration) *RedisTrafficStore {
+	return &RedisTrafficStore{client: client, bucket: bucket, retention: retention}
+}
+
+func (r RedisTrafficStore) Record(ctx context.Context, observations []traffic.Observation) error {
+	if len(observations) == 0 {
+		return nil
+	}
+
+	pipe := r.client.Pipeline()
+	for _, o := range observations {
+		bucket := o.At.Truncate(r.bucket)
+		statsKey := formatTrafficStatsKey(bucket, o.Segment.ID)
+		pipe.HIncrBy(ctx, statsKey, "samples", 1)
+		pipe.HIncrByFloat(ctx, statsKey, "speed_sum", o.Speed)
+		if o.ExpectedSpeed > 0 {
+			pipe.HIncrBy(ctx, statsKey, "expected_samples", 1)
+			pipe.HIncrByFloat(ctx, statsKey, "expected_sum", o.ExpectedSpeed)
+		}
+		pipe.Expire(ctx, statsKey, r.retention)
+
+		midpoint := o.Segment.Midpoint()
+		segmentsKey := formatTrafficSegmentsKey(bucket)
+		pipe.GeoAdd(ctx, segmentsKey, &redis.GeoLocation{Name: o.Segment.ID, Longitude: midpoint.Lon, Latitude: midpoint.Lat})
+		pipe.Expire(ctx, segmentsKey, r.retention)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("recording traffic observations: %w", err)
+	}
+	return nil
+}
+
+func (r RedisTrafficStore) Query(ctx context.Context, center gis.Point, radius float64, from, to time.Time) ([]traffic.SegmentStats, error) {
+	var res []traffic.SegmentStats
+	for bucket := from.Truncate(r.bucket); !bucket.After(to); bucket = bucket.Add(r.bucket) {
+		ids, err := r.client.GeoSearch(ctx, formatTrafficSegmentsKey(bucket), &redis.GeoSearchQuery{
+			Longitude:  center.Lon,
+			Latitude:   center.Lat,
+			Radius:     radius,
+			RadiusUnit: "m",
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("searching traffic segments: %w", err)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		pipe := r.client.Pipeline()
+		cmds := make([]*redis.MapStringStringCmd, len(ids))
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, formatTrafficStatsKey(bucket, id))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("getting traffic stats: %w", err)
+		}
+
+		for i, id := range ids {
+			segment, err := traffic.ParseSegment(id)
+			if err != nil {
+				return nil, err
+			}
+			values := cmds[i].Val()
+			if len(values) == 0 {
+				// The stats expired before the index.
+				continue
+			}
+			samples, _ := strconv.ParseInt(values["samples"], 10, 64)
+			speedSum, _ := strconv.ParseFloat(values["speed_sum"], 64)
+			expectedSamples, _ := strconv.ParseInt(values["expected_samples"], 10, 64)
+			expectedSum, _ := strconv.ParseFloat(values["expected_sum"], 64)
+			res = append(res, traffic.NewSegmentStats(segment, bucket, samples, speedSum, expectedSamples, expectedSum))
+		}
+	}
+	return res, nil
+}
+
+func formatTrafficSegmentsKey(bucket time.Time) string {
+	return fmt.Sprintf("navigation:traffic:%d", bucket.Unix())
+}
+
+func formatTrafficStatsKey(bucket time.Time, segmentID string) string {
+	return fmt.Sprintf("navigation:traffic:%d:%s", bucket.Unix(), segmentID)
+}
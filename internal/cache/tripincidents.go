@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"supmap-navigation/internal/navigation"
+	"time"
+)
+
+// RedisTripIncidentStore stores the incidents of each trip in a Redis set.
+// The set is keyed by the start of the trip, so that a new trip of the session starts from an empty set.
+type RedisTripIncidentStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewRedisTripIncidentStore(client *redis.Client, ttl time.Duration) *RedisTripIncidentStore {
+	return &RedisTripIncidentStore{client: client, ttl: ttl}
+}
+
+func (r RedisTripIncidentStore) Add(ctx context.Context, session *navigation.Session, incidentID int64) error {
+	key := formatTripIncidentsKey(session)
+	pipe := r.client.TxPipeline()
+	pipe.SAdd(ctx, key, incidentID)
+	pipe.Expire(ctx, key, r.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("storing trip incident: %w", err)
+	}
+	return nil
+}
+
+func (r RedisTripIncidentStore) Count(ctx context.Context, session *navigation.Session) (int, error) {
+	count, err := r.client.SCard(ctx, formatTripIncidentsKey(session)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("counting trip incidents: %w", err)
+	}
+	return int(count), nil
+}
+
+func formatTripIncidentsKey(session *navigation.Session) string {
+	return fmt.Sprintf("navigation:trip-incidents:%s:%d", session.ID, session.Trip.StartedAt.UnixNano())
+}
@@ -0,0 +1,192 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"supmap-navigation/internal/ws"
+	"time"
+)
+
+// claimsChannel is the pub/sub channel on which the instances announce the sessions connecting to them.
+const claimsChannel = "navigation:claims"
+
+// unregisterScript deletes the presence of a session only if it still belongs to the given instance,
+// the session may have reconnected to another instance in the meantime.
+var unregisterScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Delivery is a message relayed to the instance holding the session's socket.
+type Delivery struct {
+	SessionID string     `json:"session_id"`
+	Message   ws.Message `json:"message"`
+}
+
+// Claim announces that a session is now connected to an instance.
+type Claim struct {
+	SessionID  string `json:"session_id"`
+	InstanceID string `json:"instance_id"`
+}
+
+// Cluster shares the sessions between the instances of the service through Redis.
+// Each instance registers the sessions connected to it in a presence registry kept alive by heartbeats,
+// and listens on its own delivery channel for the messages sent to them by the other instances.
+type Cluster struct {
+	client     *redis.Client
+	logger     *slog.Logger
+	instanceID string
+	heartbeat  time.Duration
+	ttl        time.Duration
+}
+
+func NewCluster(client *redis.Client, logger *slog.Logger, instanceID string, heartbeat time.Duration) *Cluster {
+	return &Cluster{
+		client:     client,
+		logger:     logger,
+		instanceID: instanceID,
+		heartbeat:  heartbeat,
+		// Missing a couple of heartbeats is tolerated before the presence expires.
+		ttl: 3 * heartbeat,
+	}
+}
+
+// Register marks the session as connected to this instance and announces it to the other instances.
+func (c *Cluster) Register(ctx context.Context, sessionID string) error {
+	if err := c.client.Set(ctx, formatPresenceKey(sessionID), c.instanceID, c.ttl).Err(); err != nil {
+		return fmt.Errorf("registering presence: %w", err)
+	}
+
+	claim, err := json.Marshal(Claim{SessionID: sessionID, InstanceID: c.instanceID})
+	if err != nil {
+		return fmt.Errorf("marshalling claim: %w", err)
+	}
+	if err := c.client.Publish(ctx, claimsChannel, claim).Err(); err != nil {
+		return fmt.Errorf("publishing claim: %w", err)
+	}
+	return nil
+}
+
+// Unregister removes the session from the presence registry if it is still connected to this instance.
+func (c *Cluster) Unregister(ctx context.Context, sessionID string) error {
+	if err := unregisterScript.Run(ctx, c.client, []string{formatPresenceKey(sessionID)}, c.instanceID).Err(); err != nil {
+		return fmt.Errorf("unregistering presence: %w", err)
+	}
+	return nil
+}
+
+// Relay sends the message to the instance the session is connected to.
+// It returns false if the session isn't connected to another instance.
+func (c *Cluster) Relay(ctx context.Context, sessionID string, msg ws.Message) (bool, error) {
+	instanceID, err := c.client.Get(ctx, formatPresenceKey(sessionID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("getting presence: %w", err)
+	}
+	if instanceID == c.instanceID {
+		return false, nil
+	}
+
+	delivery, err := json.Marshal(Delivery{SessionID: sessionID, Message: msg})
+	if err != nil {
+		return false, fmt.Errorf("marshalling delivery: %w", err)
+	}
+	if err := c.client.Publish(ctx, formatDeliveriesChannel(instanceID), delivery).Err(); err != nil {
+		return false, fmt.Errorf("publishing delivery: %w", err)
+	}
+	return true, nil
+}
+
+// Start listens to the messages relayed to this instance and to the claims of the other instances,
+// and keeps the presence of the sessions connected to this instance alive.
+func (c *Cluster) Start(ctx context.Context, manager *ws.Manager) error {
+	deliveriesChannel := formatDeliveriesChannel(c.instanceID)
+	c.logger.Info("cluster is running", "instanceID", c.instanceID)
+	pubsub := c.client.Subscribe(ctx, deliveriesChannel, claimsChannel)
+	defer func() {
+		if err := pubsub.Close(); err != nil {
+			c.logger.Warn("failed to close cluster pubsub", "error", err)
+		}
+	}()
+
+	ticker := time.NewTicker(c.heartbeat)
+	defer ticker.Stop()
+
+	msgCh := pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok {
+				c.logger.Warn("cluster pubsub channel closed by Redis")
+				return nil
+			}
+			switch msg.Channel {
+			case deliveriesChannel:
+				c.handleDelivery(manager, msg.Payload)
+			case claimsChannel:
+				c.handleClaim(manager, msg.Payload)
+			}
+		case <-ticker.C:
+			if err := c.refresh(ctx, manager.ConnectedSessions()); err != nil {
+				c.logger.Warn("failed to refresh presences", "error", err)
+			}
+		case <-ctx.Done():
+			c.logger.Info("shutting down cluster")
+			return nil
+		}
+	}
+}
+
+func (c *Cluster) handleDelivery(manager *ws.Manager, payload string) {
+	var delivery Delivery
+	if err := json.Unmarshal([]byte(payload), &delivery); err != nil {
+		c.logger.Warn("failed to decode delivery", "error", err)
+		return
+	}
+	if !manager.DeliverLocal(delivery.SessionID, delivery.Message) {
+		// The client disconnected since, it will get the message back from the outbox.
+		c.logger.Debug("relayed message for a disconnected session", "clientID", delivery.SessionID)
+	}
+}
+
+// handleClaim stops following a session locally once it is connected to another instance,
+// so that only one instance handles the incidents on its route.
+func (c *Cluster) handleClaim(manager *ws.Manager, payload string) {
+	var claim Claim
+	if err := json.Unmarshal([]byte(payload), &claim); err != nil {
+		c.logger.Warn("failed to decode claim", "error", err)
+		return
+	}
+	if claim.InstanceID != c.instanceID {
+		manager.UnindexRoute(claim.SessionID)
+	}
+}
+
+// refresh extends the presence of the sessions connected to this instance.
+func (c *Cluster) refresh(ctx context.Context, sessionIDs []string) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	pipe := c.client.Pipeline()
+	for _, id := range sessionIDs {
+		pipe.Set(ctx, formatPresenceKey(id), c.instanceID, c.ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func formatPresenceKey(sessionID string) string {
+	return fmt.Sprintf("navigation:presence:%s", sessionID)
+}
+
+func formatDeliveriesChannel(instanceID string) string {
+	return fmt.Sprintf("navigation:deliveries:%s", instanceID)
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"github.com/caarlos0/env/v11"
+	"os"
+	"slices"
+	"time"
 )
 
 type Env string
@@ -20,6 +23,39 @@ func (e Env) IsValid() bool {
 	return false
 }
 
+// IncidentsSource is the transport the incidents are read from.
+type IncidentsSource string
+
+const (
+	IncidentsSourcePubSub IncidentsSource = "pubsub"
+	IncidentsSourceStream IncidentsSource = "stream"
+	IncidentsSourceMemory IncidentsSource = "memory"
+)
+
+func (s IncidentsSource) IsValid() bool {
+	switch s {
+	case IncidentsSourcePubSub, IncidentsSourceStream, IncidentsSourceMemory:
+		return true
+	}
+	return false
+}
+
+// ReportsForwarder is where the incidents reported by the clients are forwarded to.
+type ReportsForwarder string
+
+const (
+	ReportsForwarderHTTP   ReportsForwarder = "http"
+	ReportsForwarderMemory ReportsForwarder = "memory"
+)
+
+func (f ReportsForwarder) IsValid() bool {
+	switch f {
+	case ReportsForwarderHTTP, ReportsForwarderMemory:
+		return true
+	}
+	return false
+}
+
 type Config struct {
 	APIServerHost         string `env:"API_SERVER_HOST"`
 	APIServerPort         string `env:"API_SERVER_PORT"`
@@ -29,6 +65,119 @@ type Config struct {
 	SupmapGISHost         string `env:"SUPMAP_GIS_HOST"`
 	SupmapGISPort         string `env:"SUPMAP_GIS_PORT"`
 	Env                   Env    `env:"ENV" envDefault:"prod"`
+
+	// OffRouteTolerance is the distance (in metres) from the route above which a position is considered off-route.
+	OffRouteTolerance float64 `env:"OFF_ROUTE_TOLERANCE" envDefault:"50"`
+	// OffRouteConsecutiveFixes is the number of consecutive off-route positions needed before rerouting.
+	OffRouteConsecutiveFixes int `env:"OFF_ROUTE_CONSECUTIVE_FIXES" envDefault:"3"`
+	// ProgressInterval is the minimum delay between two "progress" messages sent to a client.
+	ProgressInterval time.Duration `env:"PROGRESS_INTERVAL" envDefault:"10s"`
+	// IncidentLookAheadDistance is the maximum distance (in metres) along the route ahead of a client
+	// for an incident to be sent to it. Zero means no limit.
+	IncidentLookAheadDistance float64 `env:"INCIDENT_LOOKAHEAD_DISTANCE" envDefault:"0"`
+	// IncidentHeadingTolerance is the maximum difference (in degrees) between the direction of an incident
+	// and the direction of a route for the incident to be on the route, zero disables the check.
+	IncidentHeadingTolerance float64 `env:"INCIDENT_HEADING_TOLERANCE" envDefault:"60"`
+	// RoutesIndexCellSize is the size (in degrees) of the cells of the routes spatial index.
+	RoutesIndexCellSize float64 `env:"ROUTES_INDEX_CELL_SIZE" envDefault:"0.01"`
+	// ManeuverAnnounceDistances are the distances (in metres) before a maneuver at which it is announced.
+	// They are sorted in decreasing order when the config is loaded.
+	ManeuverAnnounceDistances []float64 `env:"MANEUVER_ANNOUNCE_DISTANCES" envDefault:"1000,300,50"`
+	// ArrivalRadius is the distance (in metres) from the destination under which a client has arrived.
+	ArrivalRadius float64 `env:"ARRIVAL_RADIUS" envDefault:"30"`
+	// WaypointRadius is the distance (in metres) from an intermediate location under which it is reached.
+	WaypointRadius float64 `env:"WAYPOINT_RADIUS" envDefault:"50"`
+	// RouteAlternates is the number of alternative routes proposed to the clients choosing their route.
+	RouteAlternates int `env:"ROUTE_ALTERNATES" envDefault:"2"`
+	// RouteChoiceTimeout is the delay given to a client to choose its route before the fastest one is picked.
+	RouteChoiceTimeout time.Duration `env:"ROUTE_CHOICE_TIMEOUT" envDefault:"20s"`
+	// OutboxSize is the number of messages kept per session to be replayed when its client resumes.
+	OutboxSize int64 `env:"OUTBOX_SIZE" envDefault:"50"`
+	// InstanceID identifies this instance among the replicas of the service.
+	// It defaults to the hostname.
+	InstanceID string `env:"INSTANCE_ID"`
+	// PresenceHeartbeat is the interval at which the instance refreshes the presence of its connected sessions.
+	PresenceHeartbeat time.Duration `env:"PRESENCE_HEARTBEAT" envDefault:"10s"`
+	// IncidentsSource selects whether the incidents are read from the Pub/Sub channel, from a stream,
+	// or only from the admin endpoint (in memory).
+	IncidentsSource IncidentsSource `env:"INCIDENTS_SOURCE" envDefault:"pubsub"`
+	// RedisIncidentsStream is the stream the incidents are read from when IncidentsSource is "stream".
+	RedisIncidentsStream string `env:"REDIS_INCIDENTS_STREAM" envDefault:"incidents"`
+	// RedisIncidentsGroup is the consumer group shared by the instances reading the incidents stream.
+	RedisIncidentsGroup string `env:"REDIS_INCIDENTS_GROUP" envDefault:"supmap-navigation"`
+	// StreamClaimMinIdle is the delay after which the stream entries left pending by a consumer are reclaimed.
+	StreamClaimMinIdle time.Duration `env:"STREAM_CLAIM_MIN_IDLE" envDefault:"1m"`
+	// DeadLettersSize is the approximate number of rejected incident messages kept for inspection.
+	DeadLettersSize int64 `env:"DEAD_LETTERS_SIZE" envDefault:"1000"`
+	// AdminToken is the bearer token required by the admin endpoints, which are disabled when it is empty.
+	AdminToken string `env:"ADMIN_TOKEN"`
+	// TracingEndpoint is the host:port of the OTLP/HTTP collector the traces are exported to.
+	// Tracing is disabled when it is empty.
+	TracingEndpoint string `env:"TRACING_OTLP_ENDPOINT"`
+	// TracingInsecure disables TLS for the connection to the collector.
+	TracingInsecure bool `env:"TRACING_OTLP_INSECURE" envDefault:"false"`
+	// TracingSampleRatio is the fraction of the traces started by this service that are sampled.
+	TracingSampleRatio float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+	// AuthJWKSFile is the path of the JWKS holding the public keys the client tokens are signed with.
+	AuthJWKSFile string `env:"AUTH_JWKS_FILE"`
+	// AuthJWTSecret is the shared secret the client tokens are signed with (HMAC), when no JWKS is used.
+	AuthJWTSecret string `env:"AUTH_JWT_SECRET"`
+	// AuthIssuer and AuthAudience are the expected "iss" and "aud" claims of the tokens, if not empty.
+	AuthIssuer   string `env:"AUTH_ISSUER"`
+	AuthAudience string `env:"AUTH_AUDIENCE"`
+	// BreadcrumbsSize is the maximum number of positions recorded per session.
+	BreadcrumbsSize int64 `env:"BREADCRUMBS_SIZE" envDefault:"5000"`
+	// BreadcrumbsMinDistance and BreadcrumbsMinInterval downsample the recorded positions:
+	// a position is recorded once the client moved by BreadcrumbsMinDistance metres
+	// or BreadcrumbsMinInterval elapsed since the last recorded one.
+	BreadcrumbsMinDistance float64       `env:"BREADCRUMBS_MIN_DISTANCE" envDefault:"25"`
+	BreadcrumbsMinInterval time.Duration `env:"BREADCRUMBS_MIN_INTERVAL" envDefault:"30s"`
+	// BreadcrumbsTTL is how long the positions of a session are kept after the last one.
+	BreadcrumbsTTL time.Duration `env:"BREADCRUMBS_TTL" envDefault:"168h"`
+	// TrafficBucket is the duration of the time buckets the observed speeds are aggregated in.
+	TrafficBucket time.Duration `env:"TRAFFIC_BUCKET" envDefault:"5m"`
+	// TrafficRetention is how long the traffic statistics are kept.
+	TrafficRetention time.Duration `env:"TRAFFIC_RETENTION" envDefault:"24h"`
+	// TrafficMaxFixGap is the maximum delay between two positions of a session for its speed to be observed.
+	TrafficMaxFixGap time.Duration `env:"TRAFFIC_MAX_FIX_GAP" envDefault:"30s"`
+	// TrafficMinSamples is the minimum number of observations for a segment to be returned by the traffic API.
+	TrafficMinSamples int64 `env:"TRAFFIC_MIN_SAMPLES" envDefault:"3"`
+	// CongestionIncidentsChannel is the Redis channel the detected congestions are published on.
+	// Congestion detection is disabled when it is empty.
+	CongestionIncidentsChannel string `env:"CONGESTION_INCIDENTS_CHANNEL"`
+	// CongestionSpeedRatio is the fraction of the expected speed under which a session is slow.
+	CongestionSpeedRatio float64 `env:"CONGESTION_SPEED_RATIO" envDefault:"0.4"`
+	// CongestionMinSessions is the minimum number of slow sessions on a segment to detect a congestion.
+	CongestionMinSessions int `env:"CONGESTION_MIN_SESSIONS" envDefault:"3"`
+	// CongestionWindow is how long a session observed on a segment is counted.
+	CongestionWindow time.Duration `env:"CONGESTION_WINDOW" envDefault:"5m"`
+	// CongestionMinConfidence is the minimum confidence for a congestion to be published.
+	CongestionMinConfidence float64 `env:"CONGESTION_MIN_CONFIDENCE" envDefault:"0.5"`
+	// CongestionCooldown is the delay before another congestion is published in the same area.
+	CongestionCooldown time.Duration `env:"CONGESTION_COOLDOWN" envDefault:"15m"`
+	// ReportsForwarder selects whether the reports and votes of the clients are forwarded to supmap-incidents
+	// or only kept in memory (local development).
+	// The http forwarder is disabled when SupmapIncidentsHost is empty.
+	ReportsForwarder ReportsForwarder `env:"REPORTS_FORWARDER" envDefault:"http"`
+	// SupmapIncidentsHost and SupmapIncidentsPort locate the supmap-incidents API.
+	SupmapIncidentsHost string `env:"SUPMAP_INCIDENTS_HOST"`
+	SupmapIncidentsPort string `env:"SUPMAP_INCIDENTS_PORT"`
+	// SupmapIncidentsToken is the token the service authenticates with to supmap-incidents.
+	SupmapIncidentsToken string `env:"SUPMAP_INCIDENTS_TOKEN"`
+	// ReportTypeIDs are the incident types the clients can report, any type if empty.
+	ReportTypeIDs []int64 `env:"REPORT_TYPE_IDS"`
+	// ReportMaxDistance is the maximum distance (in metres) between a reported incident and the last position of the client.
+	ReportMaxDistance float64 `env:"REPORT_MAX_DISTANCE" envDefault:"500"`
+	// ReportRateLimit is the number of reports, and of votes, a session can send per ReportRateWindow.
+	ReportRateLimit  int           `env:"REPORT_RATE_LIMIT" envDefault:"5"`
+	ReportRateWindow time.Duration `env:"REPORT_RATE_WINDOW" envDefault:"10m"`
+	// IncidentsBootstrap fetches the active incidents from supmap-incidents at startup,
+	// otherwise only the incidents received since then are known.
+	IncidentsBootstrap bool `env:"INCIDENTS_BOOTSTRAP" envDefault:"false"`
+	// IncidentTTLs is how long an incident stays active without update, per incident type ID.
+	// IncidentDefaultTTL applies to the other types, zero means the incidents never expire.
+	IncidentTTLs       map[int64]time.Duration `env:"INCIDENT_TTLS"`
+	IncidentDefaultTTL time.Duration           `env:"INCIDENT_DEFAULT_TTL" envDefault:"0"`
 }
 
 func New() (*Config, error) {
@@ -40,5 +189,137 @@ func New() (*Config, error) {
 	if !cfg.Env.IsValid() {
 		return nil, fmt.Errorf("invalid env variable (must be 'prod' or 'dev')")
 	}
+
+	if cfg.OffRouteTolerance <= 0 {
+		return nil, fmt.Errorf("invalid OFF_ROUTE_TOLERANCE variable (must be positive)")
+	}
+	if cfg.OffRouteConsecutiveFixes < 1 {
+		return nil, fmt.Errorf("invalid OFF_ROUTE_CONSECUTIVE_FIXES variable (must be at least 1)")
+	}
+	if cfg.ProgressInterval < 0 {
+		return nil, fmt.Errorf("invalid PROGRESS_INTERVAL variable (must not be negative)")
+	}
+	if cfg.IncidentLookAheadDistance < 0 {
+		return nil, fmt.Errorf("invalid INCIDENT_LOOKAHEAD_DISTANCE variable (must not be negative)")
+	}
+	if cfg.IncidentHeadingTolerance < 0 || cfg.IncidentHeadingTolerance > 180 {
+		return nil, fmt.Errorf("invalid INCIDENT_HEADING_TOLERANCE variable (must be between 0 and 180)")
+	}
+	if cfg.RoutesIndexCellSize <= 0 {
+		return nil, fmt.Errorf("invalid ROUTES_INDEX_CELL_SIZE variable (must be positive)")
+	}
+	for _, d := range cfg.ManeuverAnnounceDistances {
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid MANEUVER_ANNOUNCE_DISTANCES variable (distances must be positive)")
+		}
+	}
+	if cfg.ArrivalRadius <= 0 {
+		return nil, fmt.Errorf("invalid ARRIVAL_RADIUS variable (must be positive)")
+	}
+	if cfg.WaypointRadius <= 0 {
+		return nil, fmt.Errorf("invalid WAYPOINT_RADIUS variable (must be positive)")
+	}
+	if cfg.RouteAlternates < 0 {
+		return nil, fmt.Errorf("invalid ROUTE_ALTERNATES variable (must not be negative)")
+	}
+	if cfg.RouteChoiceTimeout <= 0 {
+		return nil, fmt.Errorf("invalid ROUTE_CHOICE_TIMEOUT variable (must be positive)")
+	}
+	// The send buffer of each client has room for a whole replay (ws.MaxReplaySize).
+	if cfg.OutboxSize < 1 || cfg.OutboxSize > 256 {
+		return nil, fmt.Errorf("invalid OUTBOX_SIZE variable (must be between 1 and 256)")
+	}
+	if cfg.PresenceHeartbeat <= 0 {
+		return nil, fmt.Errorf("invalid PRESENCE_HEARTBEAT variable (must be positive)")
+	}
+	if !cfg.IncidentsSource.IsValid() {
+		return nil, fmt.Errorf("invalid INCIDENTS_SOURCE variable (must be 'pubsub', 'stream' or 'memory')")
+	}
+	if cfg.StreamClaimMinIdle <= 0 {
+		return nil, fmt.Errorf("invalid STREAM_CLAIM_MIN_IDLE variable (must be positive)")
+	}
+	if cfg.DeadLettersSize < 1 {
+		return nil, fmt.Errorf("invalid DEAD_LETTERS_SIZE variable (must be at least 1)")
+	}
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		return nil, fmt.Errorf("invalid TRACING_SAMPLE_RATIO variable (must be between 0 and 1)")
+	}
+	if cfg.AuthJWKSFile != "" && cfg.AuthJWTSecret != "" {
+		return nil, fmt.Errorf("invalid AUTH_JWKS_FILE and AUTH_JWT_SECRET variables (only one can be set)")
+	}
+	if cfg.AuthJWKSFile == "" && cfg.AuthJWTSecret == "" && cfg.Env != EnvDev {
+		return nil, fmt.Errorf("missing AUTH_JWKS_FILE or AUTH_JWT_SECRET variable (authentication can only be disabled in dev)")
+	}
+	if cfg.BreadcrumbsSize < 2 {
+		return nil, fmt.Errorf("invalid BREADCRUMBS_SIZE variable (must be at least 2)")
+	}
+	if cfg.BreadcrumbsMinDistance < 0 {
+		return nil, fmt.Errorf("invalid BREADCRUMBS_MIN_DISTANCE variable (must not be negative)")
+	}
+	if cfg.BreadcrumbsMinInterval < 0 {
+		return nil, fmt.Errorf("invalid BREADCRUMBS_MIN_INTERVAL variable (must not be negative)")
+	}
+	if cfg.BreadcrumbsTTL <= 0 {
+		return nil, fmt.Errorf("invalid BREADCRUMBS_TTL variable (must be positive)")
+	}
+	if cfg.TrafficBucket <= 0 {
+		return nil, fmt.Errorf("invalid TRAFFIC_BUCKET variable (must be positive)")
+	}
+	if cfg.TrafficRetention < cfg.TrafficBucket {
+		return nil, fmt.Errorf("invalid TRAFFIC_RETENTION variable (must be at least TRAFFIC_BUCKET)")
+	}
+	if cfg.TrafficMaxFixGap <= 0 {
+		return nil, fmt.Errorf("invalid TRAFFIC_MAX_FIX_GAP variable (must be positive)")
+	}
+	if cfg.TrafficMinSamples < 1 {
+		return nil, fmt.Errorf("invalid TRAFFIC_MIN_SAMPLES variable (must be at least 1)")
+	}
+	if cfg.CongestionSpeedRatio <= 0 || cfg.CongestionSpeedRatio >= 1 {
+		return nil, fmt.Errorf("invalid CONGESTION_SPEED_RATIO variable (must be between 0 and 1, excluded)")
+	}
+	if cfg.CongestionMinSessions < 2 {
+		return nil, fmt.Errorf("invalid CONGESTION_MIN_SESSIONS variable (must be at least 2)")
+	}
+	if cfg.CongestionWindow <= 0 {
+		return nil, fmt.Errorf("invalid CONGESTION_WINDOW variable (must be positive)")
+	}
+	if cfg.CongestionMinConfidence < 0 || cfg.CongestionMinConfidence > 1 {
+		return nil, fmt.Errorf("invalid CONGESTION_MIN_CONFIDENCE variable (must be between 0 and 1)")
+	}
+	if cfg.CongestionCooldown <= 0 {
+		return nil, fmt.Errorf("invalid CONGESTION_COOLDOWN variable (must be positive)")
+	}
+	if !cfg.ReportsForwarder.IsValid() {
+		return nil, fmt.Errorf("invalid REPORTS_FORWARDER variable (must be 'http' or 'memory')")
+	}
+	if cfg.IncidentsBootstrap && cfg.SupmapIncidentsHost == "" {
+		return nil, fmt.Errorf("missing SUPMAP_INCIDENTS_HOST variable (required by INCIDENTS_BOOTSTRAP)")
+	}
+	for _, ttl := range cfg.IncidentTTLs {
+		if ttl < 0 {
+			return nil, fmt.Errorf("invalid INCIDENT_TTLS variable (durations must not be negative)")
+		}
+	}
+	if cfg.IncidentDefaultTTL < 0 {
+		return nil, fmt.Errorf("invalid INCIDENT_DEFAULT_TTL variable (must not be negative)")
+	}
+	if cfg.ReportMaxDistance <= 0 {
+		return nil, fmt.Errorf("invalid REPORT_MAX_DISTANCE variable (must be positive)")
+	}
+	if cfg.ReportRateLimit < 1 {
+		return nil, fmt.Errorf("invalid REPORT_RATE_LIMIT variable (must be at least 1)")
+	}
+	if cfg.ReportRateWindow <= 0 {
+		return nil, fmt.Errorf("invalid REPORT_RATE_WINDOW variable (must be positive)")
+	}
+	if cfg.InstanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname for INSTANCE_ID: %w", err)
+		}
+		cfg.InstanceID = hostname
+	}
+	slices.Sort(cfg.ManeuverAnnounceDistances)
+	slices.Reverse(cfg.ManeuverAnnounceDistances)
 	return &cfg, nil
 }
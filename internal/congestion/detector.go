@@ -0,0 +1,200 @@
+package congestion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"math"
+	"strconv"
+	"supmap-navigation/internal/config"
+	"supmap-navigation/internal/incidents"
+	"supmap-navigation/internal/metrics"
+	"supmap-navigation/internal/traffic"
+)
+
+// detectedAction is the action of the messages published for the synthetic incidents.
+const detectedAction = "detected"
+
+// cooldownCellSize is the size (in degrees, about 500 metres) of the areas in which a single congestion is published per cooldown.
+const cooldownCellSize = 0.005
+
+// DetectionMessage is the message published on config.CongestionIncidentsChannel for supmap-incidents to review.
+type DetectionMessage struct {
+	Action string                      `json:"action"`
+	Data   incidents.SyntheticIncident `json:"data"`
+	// SlowSessions and ObservedSessions are the number of sessions seen slow and seen at all
+	// on the segment during config.CongestionWindow.
+	SlowSessions     int64 `json:"slow_sessions"`
+	ObservedSessions int64 `json:"observed_sessions"`
+	// ExpectedSpeed is the speed (in km/h) the routes were computed with on the segment.
+	ExpectedSpeed float64 `json:"expected_speed"`
+}
+
+// Detector flags the road segments on which several sessions drive far below the expected speed.
+// The sessions seen on each segment are kept in Redis sorted sets (scored by time) shared by the instances,
+// so that the sessions connected to different instances are counted together.
+type Detector struct {
+	config *config.Config
+	logger *slog.Logger
+	client *redis.Client
+}
+
+func NewDetector(config *config.Config, logger *slog.Logger, client *redis.Client) *Detector {
+	return &Detector{
+		config: config,
+		logger: logger,
+		client: client,
+	}
+}
+
+// Observe counts the session on the observed segments, and publishes a synthetic incident
+// for the segments on which enough sessions are slow.
+// Segments without expected speed are ignored, the slowdown can't be measured on them.
+func (d *Detector) Observe(ctx context.Context, sessionID string, observations []traffic.Observation) error {
+	measured := make([]traffic.Observation, 0, len(observations))
+	for _, o := range observations {
+		if o.ExpectedSpeed > 0 {
+			measured = append(measured, o)
+		}
+	}
+	counts, err := d.record(ctx, sessionID, measured)
+	if err != nil {
+		return err
+	}
+
+	for i, o := range measured {
+		c := counts[i]
+		if !c.slow || c.slowSessions < int64(d.config.CongestionMinSessions) {
+			continue
+		}
+
+		confidence := Confidence(c.slowSessions, c.observedSessions, d.config.CongestionMinSessions)
+		if confidence < d.config.CongestionMinConfidence {
+			continue
+		}
+		if err := d.publish(ctx, o, c.slowSessions, c.observedSessions, confidence); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Confidence grows with the share of slow sessions among the observed ones,
+// and with the number of slow sessions, up to twice minSessions.
+func Confidence(slowSessions, observedSessions int64, minSessions int) float64 {
+	if observedSessions == 0 {
+		return 0
+	}
+	share := float64(slowSessions) / float64(observedSessions)
+	support := math.Min(1, float64(slowSessions)/float64(2*minSessions))
+	return share * support
+}
+
+// segmentCounts are the sessions counted on a segment after an observation.
+type segmentCounts struct {
+	// slow tells whether the observed session is slow on the segment.
+	slow             bool
+	slowSessions     int64
+	observedSessions int64
+}
+
+// record adds the session to the sessions observed (and slow, if so) on the segments, in a single pipeline,
+// and returns, in the order of the observations, the number of slow and observed sessions within the window.
+func (d *Detector) record(ctx context.Context, sessionID string, observations []traffic.Observation) ([]segmentCounts, error) {
+	if len(observations) == 0 {
+		return nil, nil
+	}
+
+	counts := make([]segmentCounts, len(observations))
+	observedCmds := make([]*redis.IntCmd, len(observations))
+	slowCmds := make([]*redis.IntCmd, len(observations))
+	pipe := d.client.Pipeline()
+	for i, o := range observations {
+		observedKey := formatObservedKey(o.Segment.ID)
+		slowKey := formatSlowKey(o.Segment.ID)
+		at := float64(o.At.UnixMilli())
+		windowStart := strconv.FormatInt(o.At.Add(-d.config.CongestionWindow).UnixMilli(), 10)
+		counts[i].slow = o.Speed < o.ExpectedSpeed*d.config.CongestionSpeedRatio
+
+		pipe.ZAdd(ctx, observedKey, redis.Z{Score: at, Member: sessionID})
+		if counts[i].slow {
+			pipe.ZAdd(ctx, slowKey, redis.Z{Score: at, Member: sessionID})
+		} else {
+			// The session is not slow anymore on this segment.
+			pipe.ZRem(ctx, slowKey, sessionID)
+		}
+		pipe.ZRemRangeByScore(ctx, observedKey, "-inf", "("+windowStart)
+		pipe.ZRemRangeByScore(ctx, slowKey, "-inf", "("+windowStart)
+		observedCmds[i] = pipe.ZCard(ctx, observedKey)
+		slowCmds[i] = pipe.ZCard(ctx, slowKey)
+		pipe.Expire(ctx, observedKey, d.config.CongestionWindow)
+		pipe.Expire(ctx, slowKey, d.config.CongestionWindow)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("recording congestion observations: %w", err)
+	}
+
+	for i := range counts {
+		counts[i].slowSessions = slowCmds[i].Val()
+		counts[i].observedSessions = observedCmds[i].Val()
+	}
+	return counts, nil
+}
+
+// publish publishes the synthetic incident of the segment, unless one was already published
+// in the same area during the cooldown.
+func (d *Detector) publish(ctx context.Context, o traffic.Observation, slowSessions, observedSessions int64, confidence float64) error {
+	location := o.Segment.Midpoint()
+	cooldownKey := formatCooldownKey(location.Lat, location.Lon)
+	first, err := d.client.SetNX(ctx, cooldownKey, o.Segment.ID, d.config.CongestionCooldown).Result()
+	if err != nil {
+		return fmt.Errorf("checking congestion cooldown: %w", err)
+	}
+	if !first {
+		return nil
+	}
+
+	incidentType := incidents.DetectedCongestion
+	msg, err := json.Marshal(DetectionMessage{
+		Action: detectedAction,
+		Data: incidents.SyntheticIncident{
+			Incident: incidents.Incident{
+				Type:      &incidentType,
+				Lat:       location.Lat,
+				Lon:       location.Lon,
+				CreatedAt: o.At,
+				UpdatedAt: o.At,
+			},
+			Confidence: confidence,
+		},
+		SlowSessions:     slowSessions,
+		ObservedSessions: observedSessions,
+		ExpectedSpeed:    o.ExpectedSpeed,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling detection message: %w", err)
+	}
+	if err := d.client.Publish(ctx, d.config.CongestionIncidentsChannel, msg).Err(); err != nil {
+		// Let another observation publish it.
+		d.client.Del(ctx, cooldownKey)
+		return fmt.Errorf("publishing detection message: %w", err)
+	}
+
+	metrics.CongestionDetections.Inc()
+	d.logger.Info("congestion detected", "segment", o.Segment.ID, "slowSessions", slowSessions, "observedSessions", observedSessions, "confidence", confidence)
+	return nil
+}
+
+func formatObservedKey(segmentID string) string {
+	return fmt.Sprintf("navigation:congestion:observed:%s", segmentID)
+}
+
+func formatSlowKey(segmentID string) string {
+	return fmt.Sprintf("navigation:congestion:slow:%s", segmentID)
+}
+
+func formatCooldownKey(lat, lon float64) string {
+	return fmt.Sprintf("navigation:congestion:cooldown:%d:%d", int64(math.Floor(lat/cooldownCellSize)), int64(math.Floor(lon/cooldownCellSize)))
+}
@@ -0,0 +1,54 @@
+package gis
+
+import (
+	"math"
+)
+
+// Bearing returns the initial bearing (in degrees, clockwise from north, between 0 and 360) to go from a to b.
+func Bearing(a, b Point) float64 {
+	lat1 := a.Lat * degToRad
+	lat2 := b.Lat * degToRad
+	dLon := (b.Lon - a.Lon) * degToRad
+
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)/degToRad+360, 360)
+}
+
+// BearingDifference returns the smallest angle (in degrees, between 0 and 180) between two bearings.
+func BearingDifference(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
+
+// PolylineBearing returns the bearing from the first to the last point of the polyline.
+// It returns false if the polyline has less than two distinct points.
+func PolylineBearing(polyline []Point) (float64, bool) {
+	if len(polyline) < 2 || polyline[0] == polyline[len(polyline)-1] {
+		return 0, false
+	}
+	return Bearing(polyline[0], polyline[len(polyline)-1]), true
+}
+
+// Bearing returns the bearing of the polyline segment the projection is on.
+// It returns false if the polyline has no segment there.
+func (p Projection) Bearing(polyline []Point) (float64, bool) {
+	if p.SegmentIndex+1 >= len(polyline) || polyline[p.SegmentIndex] == polyline[p.SegmentIndex+1] {
+		return 0, false
+	}
+	return Bearing(polyline[p.SegmentIndex], polyline[p.SegmentIndex+1]), true
+}
+
+// MatchesDirection returns true if the heading (in degrees) differs by at most tolerance (in degrees)
+// from the bearing of the polyline segment the projection is on.
+// The direction cannot be checked on a polyline without segment, it always matches.
+func MatchesDirection(heading float64, projection Projection, polyline []Point, tolerance float64) bool {
+	bearing, ok := projection.Bearing(polyline)
+	if !ok {
+		return true
+	}
+	return BearingDifference(heading, bearing) <= tolerance
+}
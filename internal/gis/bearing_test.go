@@ -0,0 +1,97 @@
+package gis
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBearing(t *testing.T) {
+	origin := Point{Lat: 49.18, Lon: -0.37}
+	tests := []struct {
+		name string
+		to   Point
+		want float64
+	}{
+		{name: "north", to: Point{Lat: 49.19, Lon: -0.37}, want: 0},
+		{name: "east", to: Point{Lat: 49.18, Lon: -0.36}, want: 90},
+		{name: "south", to: Point{Lat: 49.17, Lon: -0.37}, want: 180},
+		{name: "west", to: Point{Lat: 49.18, Lon: -0.38}, want: 270},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// East and west are not exactly 90° and 270° on a sphere, the initial bearing is slightly off.
+			if got := Bearing(origin, tt.to); BearingDifference(got, tt.want) > 0.01 {
+				t.Errorf("got %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBearingDifference(t *testing.T) {
+	tests := []struct {
+		a, b float64
+		want float64
+	}{
+		{a: 10, b: 30, want: 20},
+		{a: 350, b: 10, want: 20},
+		{a: 10, b: 350, want: 20},
+		{a: 90, b: 270, want: 180},
+		{a: 0, b: 360, want: 0},
+		{a: 720, b: 0, want: 0},
+		{a: 45, b: 225, want: 180},
+	}
+
+	for _, tt := range tests {
+		if got := BearingDifference(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("BearingDifference(%f, %f) = %f, want %f", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesDirection(t *testing.T) {
+	// A route going east then north.
+	polyline := []Point{{Lat: 49.18, Lon: -0.37}, {Lat: 49.18, Lon: -0.36}, {Lat: 49.19, Lon: -0.36}}
+	tests := []struct {
+		name    string
+		point   Point
+		heading float64
+		want    bool
+	}{
+		{name: "same direction", point: Point{Lat: 49.1801, Lon: -0.365}, heading: 95, want: true},
+		{name: "opposite carriageway", point: Point{Lat: 49.1801, Lon: -0.365}, heading: 270, want: false},
+		{name: "beyond the tolerance", point: Point{Lat: 49.1801, Lon: -0.365}, heading: 160, want: false},
+		{name: "second segment", point: Point{Lat: 49.185, Lon: -0.3601}, heading: 350, want: true},
+		{name: "second segment opposite", point: Point{Lat: 49.185, Lon: -0.3601}, heading: 180, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projection, _ := ProjectOnPolyline(tt.point, polyline)
+			if got := MatchesDirection(tt.heading, projection, polyline, 60); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("single point polyline", func(t *testing.T) {
+		single := polyline[:1]
+		projection, _ := ProjectOnPolyline(polyline[0], single)
+		if !MatchesDirection(180, projection, single, 60) {
+			t.Error("the direction cannot be checked without segment, it must match")
+		}
+	})
+}
+
+func TestPolylineBearing(t *testing.T) {
+	if _, ok := PolylineBearing([]Point{{Lat: 49.18, Lon: -0.37}}); ok {
+		t.Error("a single point has no bearing")
+	}
+	if _, ok := PolylineBearing([]Point{{Lat: 49.18, Lon: -0.37}, {Lat: 49.18, Lon: -0.37}}); ok {
+		t.Error("identical points have no bearing")
+	}
+	got, ok := PolylineBearing([]Point{{Lat: 49.18, Lon: -0.37}, {Lat: 49.185, Lon: -0.365}, {Lat: 49.19, Lon: -0.37}})
+	if !ok || BearingDifference(got, 0) > 0.01 {
+		t.Errorf("got %f, want 0 (first to last point)", got)
+	}
+}
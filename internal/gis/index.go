@@ -0,0 +1,156 @@
+package gis
+
+import (
+	"math"
+	"sync"
+)
+
+// GridIndex is a spatial index of polylines over a regular grid of lat/lon cells.
+// A polyline is registered in every cell overlapped by the bounding box of one of its segments,
+// so a lookup only has to check the cells around a point instead of every polyline.
+type GridIndex struct {
+	mu       sync.RWMutex
+	cellSize float64 // degrees
+	cells    map[cell]map[string]struct{}
+	entries  map[string][]cell
+}
+
+type cell struct {
+	x, y int
+}
+
+// NewGridIndex creates an empty index whose cells are cellSize degrees wide.
+func NewGridIndex(cellSize float64) *GridIndex {
+	return &GridIndex{
+		cellSize: cellSize,
+		cells:    make(map[cell]map[string]struct{}),
+		entries:  make(map[string][]cell),
+	}
+}
+
+// Insert registers the polyline under the given id, replacing any polyline previously registered with it.
+func (g *GridIndex) Insert(id string, polyline []Point) {
+	covered := make(map[cell]struct{})
+	for i := range polyline {
+		a, b := polyline[i], polyline[i]
+		if i+1 < len(polyline) {
+			b = polyline[i+1]
+		}
+		g.addBoundingBox(covered, math.Min(a.Lat, b.Lat), math.Min(a.Lon, b.Lon), math.Max(a.Lat, b.Lat), math.Max(a.Lon, b.Lon))
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeUnsafe(id)
+	cells := make([]cell, 0, len(covered))
+	for c := range covered {
+		ids, ok := g.cells[c]
+		if !ok {
+			ids = make(map[string]struct{})
+			g.cells[c] = ids
+		}
+		ids[id] = struct{}{}
+		cells = append(cells, c)
+	}
+	g.entries[id] = cells
+}
+
+// Contains returns true if a polyline is registered under the given id.
+func (g *GridIndex) Contains(id string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, ok := g.entries[id]
+	return ok
+}
+
+// IDs returns the ids of the registered polylines.
+func (g *GridIndex) IDs() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	res := make([]string, 0, len(g.entries))
+	for id := range g.entries {
+		res = append(res, id)
+	}
+	return res
+}
+
+// Remove unregisters the polyline registered under the given id.
+func (g *GridIndex) Remove(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeUnsafe(id)
+}
+
+// Query returns the ids of the polylines that may pass within radius metres of the point.
+// It is a coarse filter: candidates still need an exact distance check.
+func (g *GridIndex) Query(point Point, radius float64) []string {
+	dLat := radius / EarthRadius / degToRad
+	dLon := dLat / math.Max(math.Cos(point.Lat*degToRad), 1e-6)
+
+	covered := make(map[cell]struct{})
+	g.addBoundingBox(covered, point.Lat-dLat, point.Lon-dLon, point.Lat+dLat, point.Lon+dLon)
+	return g.lookup(covered)
+}
+
+// QueryBounds returns the ids of the polylines that may pass within radius metres of the bounding box of the points.
+// It is a coarse filter: candidates still need an exact intersection check.
+func (g *GridIndex) QueryBounds(points []Point, radius float64) []string {
+	if len(points) == 0 {
+		return nil
+	}
+	minLat, minLon, maxLat, maxLon := points[0].Lat, points[0].Lon, points[0].Lat, points[0].Lon
+	for _, p := range points[1:] {
+		minLat, minLon = math.Min(minLat, p.Lat), math.Min(minLon, p.Lon)
+		maxLat, maxLon = math.Max(maxLat, p.Lat), math.Max(maxLon, p.Lon)
+	}
+	dLat := radius / EarthRadius / degToRad
+	dLon := dLat / math.Max(math.Min(math.Cos(minLat*degToRad), math.Cos(maxLat*degToRad)), 1e-6)
+
+	covered := make(map[cell]struct{})
+	g.addBoundingBox(covered, minLat-dLat, minLon-dLon, maxLat+dLat, maxLon+dLon)
+	return g.lookup(covered)
+}
+
+// lookup returns the ids of the polylines registered in the covered cells.
+func (g *GridIndex) lookup(covered map[cell]struct{}) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	found := make(map[string]struct{})
+	for c := range covered {
+		for id := range g.cells[c] {
+			found[id] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(found))
+	for id := range found {
+		res = append(res, id)
+	}
+	return res
+}
+
+func (g *GridIndex) removeUnsafe(id string) {
+	for _, c := range g.entries[id] {
+		delete(g.cells[c], id)
+		if len(g.cells[c]) == 0 {
+			delete(g.cells, c)
+		}
+	}
+	delete(g.entries, id)
+}
+
+// addBoundingBox adds to covered every cell overlapped by the bounding box.
+func (g *GridIndex) addBoundingBox(covered map[cell]struct{}, minLat, minLon, maxLat, maxLon float64) {
+	minCell, maxCell := g.cellOf(minLat, minLon), g.cellOf(maxLat, maxLon)
+	for x := minCell.x; x <= maxCell.x; x++ {
+		for y := minCell.y; y <= maxCell.y; y++ {
+			covered[cell{x: x, y: y}] = struct{}{}
+		}
+	}
+}
+
+func (g *GridIndex) cellOf(lat, lon float64) cell {
+	return cell{
+		x: int(math.Floor(lon / g.cellSize)),
+		y: int(math.Floor(lat / g.cellSize)),
+	}
+}
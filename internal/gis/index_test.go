@@ -0,0 +1,79 @@
+package gis
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// benchmarkRoutes generates n routes of 200 points (about 10 km) spread over the Caen area.
+func benchmarkRoutes(n int) map[string][]Point {
+	r := rand.New(rand.NewSource(1))
+	routes := make(map[string][]Point, n)
+	for i := 0; i < n; i++ {
+		lat, lon := 49.1+r.Float64()*0.2, -0.5+r.Float64()*0.3
+		dLat, dLon := (r.Float64()-0.5)*0.001, (r.Float64()-0.5)*0.001
+		polyline := make([]Point, 200)
+		for j := range polyline {
+			polyline[j] = Point{Lat: lat, Lon: lon}
+			lat, lon = lat+dLat, lon+dLon
+		}
+		routes[fmt.Sprintf("session-%d", i)] = polyline
+	}
+	return routes
+}
+
+var benchmarkSizes = []int{100, 1000, 10000}
+
+var incidentPoint = Point{Lat: 49.19, Lon: -0.36}
+
+// BenchmarkLinearScan is the loop used before the index: every route is checked against the incident.
+func BenchmarkLinearScan(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		routes := benchmarkRoutes(n)
+		b.Run(fmt.Sprintf("sessions=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, polyline := range routes {
+					IsPointInPolyline(incidentPoint, polyline, 30)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGridIndexQuery only checks the routes returned by the index.
+func BenchmarkGridIndexQuery(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		routes := benchmarkRoutes(n)
+		index := NewGridIndex(0.01)
+		for id, polyline := range routes {
+			index.Insert(id, polyline)
+		}
+		b.Run(fmt.Sprintf("sessions=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, id := range index.Query(incidentPoint, 30) {
+					IsPointInPolyline(incidentPoint, routes[id], 30)
+				}
+			}
+		})
+	}
+}
+
+func TestGridIndexQuery(t *testing.T) {
+	routes := benchmarkRoutes(1000)
+	index := NewGridIndex(0.01)
+	for id, polyline := range routes {
+		index.Insert(id, polyline)
+	}
+
+	candidates := make(map[string]struct{})
+	for _, id := range index.Query(incidentPoint, 30) {
+		candidates[id] = struct{}{}
+	}
+	// The index is a coarse filter, it must not miss any route passing near the point.
+	for id, polyline := range routes {
+		if _, ok := candidates[id]; !ok && IsPointInPolyline(incidentPoint, polyline, 30) {
+			t.Errorf("route %s passes near the point but is not returned by the index", id)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package gis
+
+import (
+	"math"
+)
+
+// PolylineIntersectsLine returns the first point along the polyline that is within tolerance (in metres) of the line.
+// The Distance of the projection is the distance between that point and the line.
+// It returns false if the polyline never comes within tolerance of the line.
+func PolylineIntersectsLine(polyline, line []Point, tolerance float64) (Projection, bool) {
+	if len(polyline) == 0 || len(line) == 0 {
+		return Projection{}, false
+	}
+	if len(polyline) == 1 {
+		d := DistanceToPolyline(polyline[0], line)
+		return Projection{Distance: d}, d <= tolerance
+	}
+
+	for i := 0; i < len(polyline)-1; i++ {
+		if t, ok := firstContact(polyline[i], polyline[i+1], line, tolerance); ok {
+			point := Interpolate(polyline[i], polyline[i+1], t)
+			return Projection{SegmentIndex: i, Fraction: t, Distance: DistanceToPolyline(point, line)}, true
+		}
+	}
+	return Projection{}, false
+}
+
+// PolylineIntersectsPolygon returns the first point along the polyline that is inside the polygon,
+// or within tolerance (in metres) of its boundary.
+// The polygon is given as closed rings, the first one being the exterior ring and the others holes.
+// The Distance of the projection is zero.
+// It returns false if the polyline never enters the polygon.
+func PolylineIntersectsPolygon(polyline []Point, rings [][]Point, tolerance float64) (Projection, bool) {
+	if len(polyline) == 0 || len(rings) == 0 {
+		return Projection{}, false
+	}
+	if len(polyline) == 1 {
+		if isPointInPolygon(polyline[0], rings) {
+			return Projection{}, true
+		}
+		for _, ring := range rings {
+			if DistanceToPolyline(polyline[0], ring) <= tolerance {
+				return Projection{}, true
+			}
+		}
+		return Projection{}, false
+	}
+
+	for i := 0; i < len(polyline)-1; i++ {
+		if isPointInPolygon(polyline[i], rings) {
+			return Projection{SegmentIndex: i}, true
+		}
+		// The polyline enters the polygon where it first crosses one of its rings.
+		best := math.Inf(1)
+		for _, ring := range rings {
+			if t, ok := firstContact(polyline[i], polyline[i+1], ring, tolerance); ok {
+				best = math.Min(best, t)
+			}
+		}
+		if !math.IsInf(best, 1) {
+			return Projection{SegmentIndex: i, Fraction: best}, true
+		}
+	}
+	return Projection{}, false
+}
+
+// firstContact returns the position (between 0 and 1) of the first point of the segment [A, B]
+// that is within tolerance (in metres) of the line.
+func firstContact(A, B Point, line []Point, tolerance float64) (float64, bool) {
+	if DistanceToPolyline(A, line) <= tolerance {
+		return 0, true
+	}
+
+	best := math.Inf(1)
+	for j := 0; j < len(line)-1; j++ {
+		if t, ok := segmentsIntersection(A, B, line[j], line[j+1]); ok {
+			best = math.Min(best, t)
+		}
+	}
+	// The segments do not cross but may pass close to each other, near a vertex of the line.
+	for _, vertex := range line {
+		if t, d := projectOnSegment(vertex, A, B); d <= tolerance {
+			best = math.Min(best, t)
+		}
+	}
+	if math.IsInf(best, 1) && DistanceToPolyline(B, line) <= tolerance {
+		best = 1
+	}
+	return best, !math.IsInf(best, 1)
+}
+
+// segmentsIntersection returns the position (between 0 and 1) along [A, B] of its intersection with [C, D].
+// The position of the intersection does not depend on the projection, it is computed on the raw coordinates.
+// Collinear segments are considered not to intersect, their closeness is caught by the distance checks.
+func segmentsIntersection(A, B, C, D Point) (float64, bool) {
+	rx, ry := B.Lon-A.Lon, B.Lat-A.Lat
+	sx, sy := D.Lon-C.Lon, D.Lat-C.Lat
+	denominator := rx*sy - ry*sx
+	if denominator == 0 {
+		return 0, false
+	}
+
+	qx, qy := C.Lon-A.Lon, C.Lat-A.Lat
+	t := (qx*sy - qy*sx) / denominator
+	u := (qx*ry - qy*rx) / denominator
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return t, true
+}
+
+// isPointInPolygon returns true if the point is inside the exterior ring and outside the holes.
+func isPointInPolygon(point Point, rings [][]Point) bool {
+	if !isPointInRing(point, rings[0]) {
+		return false
+	}
+	for _, hole := range rings[1:] {
+		if isPointInRing(point, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPointInRing returns true if the point is inside the closed ring, using the ray casting algorithm.
+func isPointInRing(point Point, ring []Point) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > point.Lat) != (b.Lat > point.Lat) &&
+			point.Lon < (b.Lon-a.Lon)*(point.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,204 @@
+package gis
+
+import (
+	"math"
+	"testing"
+)
+
+// route goes east along latitude 49.18, from longitude -0.37 to -0.35, with a point every 0.01°.
+var route = []Point{{Lat: 49.18, Lon: -0.37}, {Lat: 49.18, Lon: -0.36}, {Lat: 49.18, Lon: -0.35}}
+
+func TestPolylineIntersectsLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     []Point
+		want     bool
+		segment  int
+		fraction float64
+	}{
+		{
+			name:     "crossing",
+			line:     []Point{{Lat: 49.19, Lon: -0.365}, {Lat: 49.17, Lon: -0.365}},
+			want:     true,
+			segment:  0,
+			fraction: 0.5,
+		},
+		{
+			// About 11 m north of the route, along its second segment.
+			name:     "near miss within tolerance",
+			line:     []Point{{Lat: 49.1801, Lon: -0.358}, {Lat: 49.1801, Lon: -0.355}},
+			want:     true,
+			segment:  1,
+			fraction: 0.2,
+		},
+		{
+			// About 111 m north of the route.
+			name: "beyond tolerance",
+			line: []Point{{Lat: 49.181, Lon: -0.358}, {Lat: 49.181, Lon: -0.355}},
+			want: false,
+		},
+		{
+			name: "crossing the extension of the route",
+			line: []Point{{Lat: 49.19, Lon: -0.34}, {Lat: 49.17, Lon: -0.34}},
+			want: false,
+		},
+		{
+			name:     "covering the start of the route",
+			line:     []Point{{Lat: 49.18, Lon: -0.38}, {Lat: 49.18, Lon: -0.365}},
+			want:     true,
+			segment:  0,
+			fraction: 0,
+		},
+		{
+			name:     "single point line",
+			line:     []Point{{Lat: 49.18005, Lon: -0.355}},
+			want:     true,
+			segment:  1,
+			fraction: 0.5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projection, ok := PolylineIntersectsLine(route, tt.line, 30)
+			if ok != tt.want {
+				t.Fatalf("got %v, want %v", ok, tt.want)
+			}
+			if !ok {
+				return
+			}
+			if projection.SegmentIndex != tt.segment || math.Abs(projection.Fraction-tt.fraction) > 0.01 {
+				t.Errorf("got segment %d at %f, want segment %d at %f", projection.SegmentIndex, projection.Fraction, tt.segment, tt.fraction)
+			}
+			if projection.Distance > 30 {
+				t.Errorf("got distance %f, want at most the tolerance", projection.Distance)
+			}
+		})
+	}
+}
+
+func TestPolylineIntersectsPolygon(t *testing.T) {
+	square := func(minLat, minLon, maxLat, maxLon float64) []Point {
+		return []Point{{Lat: minLat, Lon: minLon}, {Lat: minLat, Lon: maxLon}, {Lat: maxLat, Lon: maxLon}, {Lat: maxLat, Lon: minLon}, {Lat: minLat, Lon: minLon}}
+	}
+	tests := []struct {
+		name     string
+		polyline []Point
+		rings    [][]Point
+		want     bool
+		segment  int
+		fraction float64
+	}{
+		{
+			name:     "entering the zone",
+			polyline: route,
+			rings:    [][]Point{square(49.17, -0.355, 49.19, -0.345)},
+			want:     true,
+			segment:  1,
+			fraction: 0.5,
+		},
+		{
+			name:     "fully inside",
+			polyline: route,
+			rings:    [][]Point{square(49.17, -0.38, 49.19, -0.34)},
+			want:     true,
+			segment:  0,
+			fraction: 0,
+		},
+		{
+			name:     "single point inside",
+			polyline: route[:1],
+			rings:    [][]Point{square(49.17, -0.38, 49.19, -0.34)},
+			want:     true,
+		},
+		{
+			name:     "passing by within tolerance",
+			polyline: route,
+			rings:    [][]Point{square(49.1801, -0.365, 49.19, -0.355)},
+			want:     true,
+			segment:  0,
+			fraction: 0.5,
+		},
+		{
+			name:     "passing by beyond tolerance",
+			polyline: route,
+			rings:    [][]Point{square(49.181, -0.365, 49.19, -0.355)},
+			want:     false,
+		},
+		{
+			// The route runs through the hole of the zone, far from its edges.
+			name:     "inside the hole",
+			polyline: route,
+			rings:    [][]Point{square(49.10, -0.45, 49.26, -0.27), square(49.15, -0.40, 49.21, -0.32)},
+			want:     false,
+		},
+		{
+			name:     "leaving the hole",
+			polyline: route,
+			rings:    [][]Point{square(49.10, -0.45, 49.26, -0.27), square(49.15, -0.40, 49.21, -0.355)},
+			want:     true,
+			segment:  1,
+			fraction: 0.5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projection, ok := PolylineIntersectsPolygon(tt.polyline, tt.rings, 30)
+			if ok != tt.want {
+				t.Fatalf("got %v, want %v", ok, tt.want)
+			}
+			if ok && (projection.SegmentIndex != tt.segment || math.Abs(projection.Fraction-tt.fraction) > 0.01) {
+				t.Errorf("got segment %d at %f, want segment %d at %f", projection.SegmentIndex, projection.Fraction, tt.segment, tt.fraction)
+			}
+		})
+	}
+}
+
+func TestIsPointInPolygon(t *testing.T) {
+	exterior := []Point{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 10}, {Lat: 10, Lon: 0}, {Lat: 0, Lon: 0}}
+	hole := []Point{{Lat: 4, Lon: 4}, {Lat: 4, Lon: 6}, {Lat: 6, Lon: 6}, {Lat: 6, Lon: 4}, {Lat: 4, Lon: 4}}
+	tests := []struct {
+		name  string
+		point Point
+		want  bool
+	}{
+		{name: "inside", point: Point{Lat: 2, Lon: 2}, want: true},
+		{name: "outside", point: Point{Lat: 12, Lon: 2}, want: false},
+		{name: "in the hole", point: Point{Lat: 5, Lon: 5}, want: false},
+		{name: "between the hole and the exterior", point: Point{Lat: 5, Lon: 8}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPointInPolygon(tt.point, [][]Point{exterior, hole}); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegmentsIntersection(t *testing.T) {
+	a, b := Point{Lat: 0, Lon: 0}, Point{Lat: 0, Lon: 4}
+	tests := []struct {
+		name string
+		c, d Point
+		want bool
+		t    float64
+	}{
+		{name: "crossing", c: Point{Lat: -1, Lon: 1}, d: Point{Lat: 1, Lon: 1}, want: true, t: 0.25},
+		{name: "touching the end", c: Point{Lat: 0, Lon: 4}, d: Point{Lat: 1, Lon: 4}, want: true, t: 1},
+		{name: "disjoint", c: Point{Lat: 1, Lon: 1}, d: Point{Lat: 2, Lon: 1}, want: false},
+		{name: "parallel", c: Point{Lat: 1, Lon: 0}, d: Point{Lat: 1, Lon: 4}, want: false},
+		{name: "collinear", c: Point{Lat: 0, Lon: 1}, d: Point{Lat: 0, Lon: 2}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := segmentsIntersection(a, b, tt.c, tt.d)
+			if ok != tt.want || (ok && math.Abs(got-tt.t) > 1e-9) {
+				t.Errorf("got (%f, %v), want (%f, %v)", got, ok, tt.t, tt.want)
+			}
+		})
+	}
+}
@@ -5,8 +5,8 @@ import (
 )
 
 type Point struct {
-	Lat float64
-	Lon float64
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
 }
 
 // EarthRadius in meters
@@ -49,8 +49,95 @@ func IsPointInPolyline(point Point, polyline []Point, tolerance float64) bool {
 	return false
 }
 
+// DistanceToPolyline returns the minimum distance (in metres) between the point and the polyline.
+// An empty polyline is infinitely far away.
+func DistanceToPolyline(point Point, polyline []Point) float64 {
+	if len(polyline) == 0 {
+		return math.Inf(1)
+	}
+	if len(polyline) == 1 {
+		return Haversine(point, polyline[0])
+	}
+
+	minDistance := math.Inf(1)
+	for i := 0; i < len(polyline)-1; i++ {
+		minDistance = math.Min(minDistance, distanceToSegment(point, polyline[i], polyline[i+1]))
+	}
+	return minDistance
+}
+
+// Projection is the orthogonal projection of a point onto a polyline.
+type Projection struct {
+	// SegmentIndex is the index of the first point of the closest segment.
+	SegmentIndex int
+	// Fraction is the position of the projection along the segment, between 0 and 1.
+	Fraction float64
+	// Distance between the point and its projection, in metres.
+	Distance float64
+}
+
+// ProjectOnPolyline returns the projection of the point onto the closest segment of the polyline.
+// It returns false if the polyline is empty.
+func ProjectOnPolyline(point Point, polyline []Point) (Projection, bool) {
+	if len(polyline) == 0 {
+		return Projection{}, false
+	}
+	if len(polyline) == 1 {
+		return Projection{Distance: Haversine(point, polyline[0])}, true
+	}
+
+	best := Projection{Distance: math.Inf(1)}
+	for i := 0; i < len(polyline)-1; i++ {
+		t, d := projectOnSegment(point, polyline[i], polyline[i+1])
+		if d < best.Distance {
+			best = Projection{SegmentIndex: i, Fraction: t, Distance: d}
+		}
+	}
+	return best, true
+}
+
+// DistanceAlong returns the distance (in metres) travelled along the polyline up to the projection,
+// given the cumulative distances of the polyline (see CumulativeDistances).
+func (p Projection) DistanceAlong(cumulative []float64) float64 {
+	if len(cumulative) == 0 {
+		return 0
+	}
+	along := cumulative[p.SegmentIndex]
+	if p.SegmentIndex+1 < len(cumulative) {
+		along += p.Fraction * (cumulative[p.SegmentIndex+1] - cumulative[p.SegmentIndex])
+	}
+	return along
+}
+
+// Interpolate returns the point at the given fraction (between 0 and 1) of the segment [A, B].
+// The interpolation is linear on the coordinates, which is precise enough for short segments.
+func Interpolate(A, B Point, fraction float64) Point {
+	return Point{
+		Lat: A.Lat + (B.Lat-A.Lat)*fraction,
+		Lon: A.Lon + (B.Lon-A.Lon)*fraction,
+	}
+}
+
+// CumulativeDistances returns, for each point of the polyline, the distance (in metres)
+// travelled along the polyline from its first point.
+func CumulativeDistances(polyline []Point) []float64 {
+	res := make([]float64, len(polyline))
+	for i := 1; i < len(polyline); i++ {
+		res[i] = res[i-1] + Haversine(polyline[i-1], polyline[i])
+	}
+	return res
+}
+
 // distanceToSegment calculates the minimum distance (in metres) from point P to the segment [A, B].
 func distanceToSegment(P, A, B Point) float64 {
+	_, d := projectOnSegment(P, A, B)
+	return d
+}
+
+// projectOnSegment projects point P onto the segment [A, B].
+// It returns the position of the projection along the segment (between 0 and 1)
+// and the distance (in metres) between P and its projection.
+func projectOnSegment(P, A, B Point) (float64, float64) {
 	// Convert lat/lon to radians
 	lat1 := A.Lat * degToRad
 	lon1 := A.Lon * degToRad
@@ -75,7 +162,7 @@ func distanceToSegment(P, A, B Point) float64 {
 
 	// Degenerate segment case (A == B)
 	if dx == 0 && dy == 0 {
-		return math.Hypot(xP-xA, yP-yA)
+		return 0, math.Hypot(xP-xA, yP-yA)
 	}
 
 	// Orthogonal projection of point P onto segment AB
@@ -85,5 +172,5 @@ func distanceToSegment(P, A, B Point) float64 {
 	yProj := yA + t*dy
 
 	// Euclidean distance in metres
-	return math.Hypot(xP-xProj, yP-yProj)
+	return t, math.Hypot(xP-xProj, yP-yProj)
 }
@@ -5,11 +5,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"net/url"
+	"strconv"
+	"supmap-navigation/internal/metrics"
+	"supmap-navigation/internal/tracing"
 	"time"
 )
 
+var tracer = tracing.Tracer("supmap-navigation/internal/gis/routing")
+
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
@@ -37,7 +46,25 @@ func NewClient(baseURL string, options ...ClientOptions) *Client {
 	}
 }
 
+// CalculateRoute returns the best route matching the request.
 func (c *Client) CalculateRoute(ctx context.Context, routeRequest RouteRequest) (*Route, error) {
+	routes, err := c.CalculateRoutes(ctx, routeRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &routes[0], nil
+}
+
+// CalculateRoutes returns the best route matching the request, followed by the alternates if any were requested.
+func (c *Client) CalculateRoutes(ctx context.Context, routeRequest RouteRequest) (routes []Route, err error) {
+	ctx, span := tracer.Start(ctx, "routing.CalculateRoutes", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		if err != nil {
+			tracing.Fail(span, err)
+		}
+		span.End()
+	}()
+
 	reqURL, err := url.Parse(c.baseURL + "/route")
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse URL: %w", err)
@@ -52,12 +79,18 @@ func (c *Client) CalculateRoute(ctx context.Context, routeRequest RouteRequest)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	// Let supmap-gis continue the trace.
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		metrics.GISRequestDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 	defer resp.Body.Close()
+	metrics.GISRequestDuration.WithLabelValues(strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
@@ -72,5 +105,5 @@ func (c *Client) CalculateRoute(ctx context.Context, routeRequest RouteRequest)
 		return nil, fmt.Errorf("no route found")
 	}
 
-	return &routeResponse.Data[0], nil
+	return routeResponse.Data, nil
 }
@@ -3,7 +3,6 @@ package gis
 import (
 	"errors"
 	"fmt"
-	"supmap-navigation/internal/navigation"
 )
 
 type RouteRequest struct {
@@ -21,6 +20,9 @@ func (r RouteRequest) Validate() error {
 	if !r.Costing.IsValid() {
 		return errors.New(fmt.Sprintf("costing %q is invalid", r.Costing))
 	}
+	if r.CostingOptions != nil {
+		return r.CostingOptions.Validate()
+	}
 	return nil
 }
 
@@ -84,6 +86,15 @@ type CostingOptions struct {
 	UseTracks   *Ratio `json:"use_tracks,omitempty"`
 }
 
+func (co CostingOptions) Validate() error {
+	for name, r := range map[string]*Ratio{"use_highways": co.UseHighways, "use_tolls": co.UseTolls, "use_tracks": co.UseTracks} {
+		if r != nil && !r.IsValid() {
+			return fmt.Errorf("costing option %s must be between 0 and 1", name)
+		}
+	}
+	return nil
+}
+
 // Response specific
 
 type Route Trip
@@ -116,9 +127,14 @@ type Summary struct {
 }
 
 type Leg struct {
-	Maneuvers []Maneuver         `json:"maneuvers"`
-	Summary   Summary            `json:"summary"`
-	Shape     []navigation.Point `json:"shape"`
+	Maneuvers []Maneuver `json:"maneuvers"`
+	Summary   Summary    `json:"summary"`
+	Shape     []Point    `json:"shape"`
+}
+
+type Point struct {
+	Lat float64 `json:"latitude"`
+	Lon float64 `json:"longitude"`
 }
 
 type LocationResponse struct {
@@ -0,0 +1,63 @@
+package incidents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"net/http"
+	"time"
+)
+
+// Client fetches the active incidents from the supmap-incidents API, to fill the store at startup.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+func NewClient(baseURL string, token string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL:    baseURL,
+		token:      token,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// ListActive returns the incidents not deleted yet.
+func (c *Client) ListActive(ctx context.Context) ([]Incident, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/incidents", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var res struct {
+		Data []Incident `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	active := res.Data[:0]
+	for _, incident := range res.Data {
+		if incident.DeletedAt == nil {
+			active = append(active, incident)
+		}
+	}
+	return active, nil
+}
@@ -0,0 +1,125 @@
+package incidents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"supmap-navigation/internal/gis"
+)
+
+type GeometryType string
+
+const (
+	LineString GeometryType = "LineString"
+	Polygon    GeometryType = "Polygon"
+)
+
+// Geometry is the GeoJSON geometry of the stretch (LineString) or zone (Polygon) covered by an incident.
+type Geometry struct {
+	Type GeometryType
+	// Line is the polyline of a LineString.
+	Line []gis.Point
+	// Rings are the closed rings of a Polygon, the first one is the exterior ring and the others are holes.
+	Rings [][]gis.Point
+}
+
+// geoJSONGeometry is the GeoJSON representation of a Geometry, coordinates are in [lon, lat] order.
+type geoJSONGeometry struct {
+	Type        GeometryType    `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func (g *Geometry) UnmarshalJSON(data []byte) error {
+	var raw geoJSONGeometry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch raw.Type {
+	case LineString:
+		var coordinates [][]float64
+		if err := json.Unmarshal(raw.Coordinates, &coordinates); err != nil {
+			return fmt.Errorf("invalid LineString coordinates: %w", err)
+		}
+		line, err := toPoints(coordinates)
+		if err != nil {
+			return err
+		}
+		if len(line) < 2 {
+			return errors.New("a LineString needs at least 2 positions")
+		}
+		*g = Geometry{Type: LineString, Line: line}
+	case Polygon:
+		var coordinates [][][]float64
+		if err := json.Unmarshal(raw.Coordinates, &coordinates); err != nil {
+			return fmt.Errorf("invalid Polygon coordinates: %w", err)
+		}
+		if len(coordinates) == 0 {
+			return errors.New("a Polygon needs an exterior ring")
+		}
+		rings := make([][]gis.Point, 0, len(coordinates))
+		for _, c := range coordinates {
+			ring, err := toPoints(c)
+			if err != nil {
+				return err
+			}
+			if len(ring) < 4 || ring[0] != ring[len(ring)-1] {
+				return errors.New("a Polygon ring needs at least 4 positions, the last one equal to the first one")
+			}
+			rings = append(rings, ring)
+		}
+		*g = Geometry{Type: Polygon, Rings: rings}
+	default:
+		return fmt.Errorf("unsupported geometry type %q (must be LineString or Polygon)", raw.Type)
+	}
+	return nil
+}
+
+func (g Geometry) MarshalJSON() ([]byte, error) {
+	var coordinates any
+	switch g.Type {
+	case LineString:
+		coordinates = toCoordinates(g.Line)
+	case Polygon:
+		rings := make([][][2]float64, 0, len(g.Rings))
+		for _, ring := range g.Rings {
+			rings = append(rings, toCoordinates(ring))
+		}
+		coordinates = rings
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+
+	raw, err := json.Marshal(coordinates)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(geoJSONGeometry{Type: g.Type, Coordinates: raw})
+}
+
+// Points returns the points delimiting the geometry: the polyline of a LineString, the exterior ring of a Polygon.
+func (g Geometry) Points() []gis.Point {
+	if g.Type == Polygon {
+		return g.Rings[0]
+	}
+	return g.Line
+}
+
+func toPoints(coordinates [][]float64) ([]gis.Point, error) {
+	res := make([]gis.Point, 0, len(coordinates))
+	for _, c := range coordinates {
+		if len(c) < 2 {
+			return nil, errors.New("a position needs a longitude and a latitude")
+		}
+		res = append(res, gis.Point{Lat: c[1], Lon: c[0]})
+	}
+	return res, nil
+}
+
+func toCoordinates(points []gis.Point) [][2]float64 {
+	res := make([][2]float64, 0, len(points))
+	for _, p := range points {
+		res = append(res, [2]float64{p.Lon, p.Lat})
+	}
+	return res
+}
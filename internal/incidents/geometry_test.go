@@ -0,0 +1,120 @@
+package incidents
+
+import (
+	"encoding/json"
+	"reflect"
+	"supmap-navigation/internal/gis"
+	"testing"
+)
+
+func TestGeometryJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		geojson  string
+		geometry Geometry
+	}{
+		{
+			name:    "LineString",
+			geojson: `{"type":"LineString","coordinates":[[-0.37,49.18],[-0.36,49.185]]}`,
+			geometry: Geometry{
+				Type: LineString,
+				Line: []gis.Point{{Lat: 49.18, Lon: -0.37}, {Lat: 49.185, Lon: -0.36}},
+			},
+		},
+		{
+			name:    "Polygon with a hole",
+			geojson: `{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[4,4],[6,4],[6,6],[4,6],[4,4]]]}`,
+			geometry: Geometry{
+				Type: Polygon,
+				Rings: [][]gis.Point{
+					{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 10}, {Lat: 10, Lon: 0}, {Lat: 0, Lon: 0}},
+					{{Lat: 4, Lon: 4}, {Lat: 4, Lon: 6}, {Lat: 6, Lon: 6}, {Lat: 6, Lon: 4}, {Lat: 4, Lon: 4}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Geometry
+			if err := json.Unmarshal([]byte(tt.geojson), &got); err != nil {
+				t.Fatalf("failed to unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.geometry) {
+				t.Fatalf("got %+v, want %+v", got, tt.geometry)
+			}
+
+			data, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+			if string(data) != tt.geojson {
+				t.Errorf("got %s, want %s", data, tt.geojson)
+			}
+		})
+	}
+}
+
+func TestGeometryJSONErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		geojson string
+	}{
+		{name: "unsupported type", geojson: `{"type":"Point","coordinates":[-0.37,49.18]}`},
+		{name: "LineString with a single position", geojson: `{"type":"LineString","coordinates":[[-0.37,49.18]]}`},
+		{name: "position without latitude", geojson: `{"type":"LineString","coordinates":[[-0.37],[-0.36,49.18]]}`},
+		{name: "Polygon without ring", geojson: `{"type":"Polygon","coordinates":[]}`},
+		{name: "open ring", geojson: `{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10]]]}`},
+		{name: "invalid coordinates", geojson: `{"type":"LineString","coordinates":"nope"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Geometry
+			if err := json.Unmarshal([]byte(tt.geojson), &got); err == nil {
+				t.Errorf("expected an error, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestLocateIncident(t *testing.T) {
+	route := []gis.Point{{Lat: 49.18, Lon: -0.37}, {Lat: 49.18, Lon: -0.36}, {Lat: 49.18, Lon: -0.35}}
+	tests := []struct {
+		name     string
+		incident string
+		want     bool
+	}{
+		{name: "point on the route", incident: `{"lat":49.18005,"lon":-0.365}`, want: true},
+		{name: "point away from the route", incident: `{"lat":49.19,"lon":-0.365}`, want: false},
+		{
+			// The point is away from the route but the road works it delimits cross it.
+			name:     "line crossing the route",
+			incident: `{"lat":49.19,"lon":-0.365,"geometry":{"type":"LineString","coordinates":[[-0.365,49.19],[-0.365,49.17]]}}`,
+			want:     true,
+		},
+		{
+			name:     "zone covering the route",
+			incident: `{"lat":49.19,"lon":-0.365,"geometry":{"type":"Polygon","coordinates":[[[-0.355,49.17],[-0.345,49.17],[-0.345,49.19],[-0.355,49.19],[-0.355,49.17]]]}}`,
+			want:     true,
+		},
+		{
+			// The geometry takes precedence over the point.
+			name:     "zone away from the route",
+			incident: `{"lat":49.18,"lon":-0.365,"geometry":{"type":"Polygon","coordinates":[[[-0.355,49.19],[-0.345,49.19],[-0.345,49.2],[-0.355,49.2],[-0.355,49.19]]]}}`,
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var incident Incident
+			if err := json.Unmarshal([]byte(tt.incident), &incident); err != nil {
+				t.Fatalf("failed to unmarshal incident: %v", err)
+			}
+			if _, ok := locateIncident(&incident, route); ok != tt.want {
+				t.Errorf("got %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
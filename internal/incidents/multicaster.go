@@ -1,137 +1,267 @@
 package incidents
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"log"
+	"slices"
+	"supmap-navigation/internal/config"
 	"supmap-navigation/internal/gis"
-	routing "supmap-navigation/internal/gis/routing"
+	"supmap-navigation/internal/metrics"
 	"supmap-navigation/internal/navigation"
+	"supmap-navigation/internal/reroute"
+	"supmap-navigation/internal/tracing"
 	"supmap-navigation/internal/ws"
 	"time"
 )
 
+var tracer = tracing.Tracer("supmap-navigation/internal/incidents")
+
+// incidentRouteTolerance is the maximum distance (in metres) between an incident and a route for it to be on the route.
+const incidentRouteTolerance = 30
+
+// incidentExpiryInterval is the interval at which the incidents without update are expired.
+const incidentExpiryInterval = time.Minute
+
 type Multicaster struct {
-	Manager       *ws.Manager
-	SessionCache  navigation.SessionCache
-	RoutingClient *routing.Client
+	Config       *config.Config
+	Manager      *ws.Manager
+	SessionCache navigation.SessionCache
+	Rerouter     *reroute.Rerouter
+	// Store keeps the active incidents, sent to the sessions when their route changes.
+	Store *Store
+	// TripIncidents records the incidents sent during each trip, for the arrival summary.
+	TripIncidents navigation.TripIncidentStore
 }
 
-func NewMulticaster(manager *ws.Manager, sessionCache navigation.SessionCache, routingClient *routing.Client) *Multicaster {
+func NewMulticaster(config *config.Config, manager *ws.Manager, sessionCache navigation.SessionCache, rerouter *reroute.Rerouter, store *Store, tripIncidents navigation.TripIncidentStore) *Multicaster {
 	return &Multicaster{
+		Config:        config,
 		Manager:       manager,
 		SessionCache:  sessionCache,
-		RoutingClient: routingClient,
+		Rerouter:      rerouter,
+		Store:         store,
+		TripIncidents: tripIncidents,
 	}
 }
 
-// MulticastIncident notifies each client if it's impacted by the incident.
-// If the incident needs a route recalculation and is certified, the new route is sent to the clients.
+// MulticastIncident notifies each session if it's impacted by the incident.
+// If the incident needs a route recalculation and is certified, the new route is sent to the sessions.
+// The sessions the incident was already sent to always get its updates and deletion,
+// even if it is not on their route anymore.
+// Disconnected sessions get the messages when they resume.
 func (m *Multicaster) MulticastIncident(ctx context.Context, incident *Incident, action string) {
-	m.Manager.RLock()
-	defer m.Manager.RUnlock()
+	ctx, span := tracer.Start(ctx, "incidents.MulticastIncident", trace.WithAttributes(
+		attribute.Int64("incident.id", incident.ID),
+		attribute.String("incident.action", action),
+	))
+	start := time.Now()
+	matched := 0
+	defer func() {
+		metrics.IncidentMessages.WithLabelValues(action).Inc()
+		metrics.MulticastDuration.Observe(time.Since(start).Seconds())
+		metrics.MulticastMatchedSessions.Observe(float64(matched))
+		span.SetAttributes(attribute.Int("multicast.matched_sessions", matched))
+		span.End()
+	}()
+
+	notified, known := m.Store.Apply(incident, Action(action), time.Now())
+	// An unknown incident (received before this instance started) is deleted for the sessions it is on the route of.
+	if action == string(Deleted) && known {
+		for _, sessionID := range notified {
+			m.sendIncident(ctx, sessionID, incident, action, nil)
+		}
+		matched = len(notified)
+		return
+	}
 
-	for sessionID, client := range m.Manager.ClientsUnsafe() {
+	sent := make(map[string]struct{})
+	for _, sessionID := range m.sessionsNear(incident) {
 		session, err := m.SessionCache.GetSession(ctx, sessionID)
+		if errors.Is(err, navigation.ErrSessionNotFound) {
+			// The session expired.
+			m.Manager.UnindexRoute(sessionID)
+			continue
+		}
 		if err != nil || session == nil {
 			continue
 		}
-		if !m.isIncidentOnRoute(incident, session) {
+		distance, ok := m.isIncidentOnRoute(incident, session)
+		if !ok {
 			continue
 		}
+		matched++
 
 		if incident.Type != nil && action == "certified" && incident.Type.NeedRecalculation {
-			m.handleRouteRecalculation(ctx, client, session)
-			m.sendIncident(client, incident, action)
+			m.handleRouteRecalculation(ctx, session)
+			m.sendIncident(ctx, sessionID, incident, action, &distance)
 		} else {
-			m.sendIncident(client, incident, action)
+			m.sendIncident(ctx, sessionID, incident, action, &distance)
+		}
+		sent[sessionID] = struct{}{}
+		if action != string(Deleted) {
+			m.recordIncident(ctx, session, incident)
+			m.Store.MarkNotified(incident.ID, sessionID)
+		}
+	}
+
+	for _, sessionID := range notified {
+		if _, ok := sent[sessionID]; !ok {
+			m.sendIncident(ctx, sessionID, incident, action, nil)
+			matched++
 		}
 	}
 }
 
-// isIncidentOnRoute returns true if an incident is on the current route.
-func (m *Multicaster) isIncidentOnRoute(incident *Incident, session *navigation.Session) bool {
-	return gis.IsPointInPolyline(
-		gis.Point{Lat: incident.Lat, Lon: incident.Lon},
-		convertNavPointsToGIS(session.Route.Polyline),
-		30,
-	)
+// ExpireIncidents periodically removes the incidents without update for longer than the TTL of their type,
+// and retracts them from the sessions they were sent to with the "expired" action.
+func (m *Multicaster) ExpireIncidents(ctx context.Context) {
+	ticker := time.NewTicker(incidentExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, retraction := range m.Store.Expire(now, m.incidentTTL) {
+				for _, sessionID := range retraction.Sessions {
+					m.sendIncident(ctx, sessionID, retraction.Incident, string(Expired), nil)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
-// handleRouteRecalculation handles the route recalculation and notifies the client.
-func (m *Multicaster) handleRouteRecalculation(ctx context.Context, client *ws.Client, session *navigation.Session) {
-	origin := navigation.Location{
-		Lat: session.LastPosition.Lat,
-		Lon: session.LastPosition.Lon,
+// incidentTTL returns how long the incident stays active without update, zero if it never expires.
+func (m *Multicaster) incidentTTL(incident *Incident) time.Duration {
+	if incident.Type != nil {
+		if ttl, ok := m.Config.IncidentTTLs[incident.Type.ID]; ok {
+			return ttl
+		}
 	}
-	session.Route.Locations[0] = origin
+	return m.Config.IncidentDefaultTTL
+}
 
-	alternates := 0
-	req := routing.RouteRequest{
-		Locations:  convertLocationsToLocationRequests(session.Route.Locations),
-		Costing:    "auto",
-		Alternates: &alternates,
+// HandleRoute implements ws.RouteHandler: it sends the active incidents on the new route of the session,
+// so that the client knows about the incidents reported before it started or rerouted.
+// The snapshot is sent even if empty, it replaces the incidents of the previous route.
+func (m *Multicaster) HandleRoute(ctx context.Context, session *navigation.Session) {
+	payload := IncidentsSnapshotPayload{Incidents: []IncidentPayload{}}
+	for _, active := range m.Store.Active() {
+		distance, ok := m.isIncidentOnRoute(active.Incident, session)
+		if !ok {
+			continue
+		}
+		payload.Incidents = append(payload.Incidents, IncidentPayload{
+			Incident:      active.Incident,
+			Action:        string(active.Action),
+			RouteDistance: &distance,
+		})
+		m.Store.MarkNotified(active.Incident.ID, session.ID)
+		m.recordIncident(ctx, session, active.Incident)
 	}
+	slices.SortFunc(payload.Incidents, func(a, b IncidentPayload) int {
+		return cmp.Compare(*a.RouteDistance, *b.RouteDistance)
+	})
 
-	newRoute, err := m.RoutingClient.CalculateRoute(ctx, req)
+	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
-		log.Println(err)
+		log.Printf("failed to marshal incidents snapshot: %v", err)
 		return
 	}
+	m.Manager.Send(ctx, session.ID, ws.Message{
+		Type: "incidents_snapshot",
+		Data: jsonPayload,
+	})
+}
 
-	var newPolyline []navigation.Point
-	for _, leg := range newRoute.Legs {
-		newPolyline = append(newPolyline, leg.Shape...)
+// recordIncident adds the incident to the ones encountered during the trip, for the arrival summary.
+// The session itself is not saved: it may have been updated by its client since it was read.
+func (m *Multicaster) recordIncident(ctx context.Context, session *navigation.Session, incident *Incident) {
+	if err := m.TripIncidents.Add(ctx, session, incident.ID); err != nil {
+		log.Printf("failed to record trip incident: %v", err)
 	}
+}
 
-	// Met à jour la session (optionnel)
-	session.Route.Polyline = newPolyline
-	session.UpdatedAt = time.Now()
-	if err = m.SessionCache.SetSession(ctx, session); err != nil {
-		log.Printf("failed to save session to cache: %v", err)
+// sessionsNear returns the sessions whose route may pass near the incident, its geometry if it has one.
+func (m *Multicaster) sessionsNear(incident *Incident) []string {
+	if incident.Geometry != nil {
+		return m.Manager.SessionsNearArea(incident.Geometry.Points(), incidentRouteTolerance)
 	}
-
-	payload, _ := json.Marshal(struct {
-		Route *routing.Route `json:"route"`
-		Info  string         `json:"info"`
-	}{
-		Route: newRoute,
-		Info:  "recalculated_due_to_incident",
-	})
-	client.Send(ws.Message{
-		Type: "route",
-		Data: payload,
-	})
+	return m.Manager.SessionsNear(gis.Point{Lat: incident.Lat, Lon: incident.Lon}, incidentRouteTolerance)
 }
 
-// sendIncident sends a single incident to the client.
-func (m *Multicaster) sendIncident(client *ws.Client, incident *Incident, action string) {
-	incidentPayload := IncidentPayload{
-		Incident: incident,
-		Action:   action,
+// isIncidentOnRoute returns true if an incident is on the part of the current route ahead of the client,
+// along with the distance (in metres) to reach it.
+// Incidents further than the configured look-ahead distance are ignored,
+// as well as the directed ones going the other way (e.g. on the opposite carriageway).
+func (m *Multicaster) isIncidentOnRoute(incident *Incident, session *navigation.Session) (float64, bool) {
+	ahead := session.Route.PolylineAhead(session.LastPosition)
+	projection, ok := locateIncident(incident, ahead)
+	if !ok {
+		return 0, false
 	}
-	jsonPayload, _ := json.Marshal(incidentPayload)
-	client.Send(ws.Message{
-		Type: "incident",
-		Data: jsonPayload,
-	})
+	if heading, directed := incident.Direction(); directed && m.Config.IncidentHeadingTolerance > 0 &&
+		!gis.MatchesDirection(heading, projection, ahead, m.Config.IncidentHeadingTolerance) {
+		return 0, false
+	}
+
+	distance := projection.DistanceAlong(gis.CumulativeDistances(ahead))
+	if m.Config.IncidentLookAheadDistance > 0 && distance > m.Config.IncidentLookAheadDistance {
+		return 0, false
+	}
+	return distance, true
 }
 
-func convertLocationsToLocationRequests(points []navigation.Location) []routing.LocationRequest {
-	res := make([]routing.LocationRequest, len(points))
-	for i, p := range points {
-		res[i] = routing.LocationRequest{
-			Lat: p.Lat,
-			Lon: p.Lon,
+// locateIncident returns where the polyline first meets the incident:
+// its line or zone if it has a geometry, its point otherwise.
+func locateIncident(incident *Incident, polyline []gis.Point) (gis.Projection, bool) {
+	if incident.Geometry != nil {
+		switch incident.Geometry.Type {
+		case LineString:
+			return gis.PolylineIntersectsLine(polyline, incident.Geometry.Line, incidentRouteTolerance)
+		case Polygon:
+			return gis.PolylineIntersectsPolygon(polyline, incident.Geometry.Rings, incidentRouteTolerance)
 		}
 	}
-	return res
+	projection, ok := gis.ProjectOnPolyline(gis.Point{Lat: incident.Lat, Lon: incident.Lon}, polyline)
+	return projection, ok && projection.Distance <= incidentRouteTolerance
+}
+
+// handleRouteRecalculation recalculates the route of the session and sends it.
+// If its client is connected, the recalculation is run by its read loop,
+// otherwise nothing else updates the session in the meantime.
+func (m *Multicaster) handleRouteRecalculation(ctx context.Context, session *navigation.Session) {
+	if m.Manager.Dispatch(session.ID, m.reroute) {
+		return
+	}
+	m.reroute(ctx, session)
+	if err := m.SessionCache.SetSession(ctx, session); err != nil {
+		log.Printf("failed to save session to cache: %v", err)
+	}
 }
 
-func convertNavPointsToGIS(points []navigation.Point) []gis.Point {
-	res := make([]gis.Point, len(points))
-	for i, p := range points {
-		res[i] = gis.Point{Lat: p.Lat, Lon: p.Lon}
+func (m *Multicaster) reroute(ctx context.Context, session *navigation.Session) {
+	if err := m.Rerouter.Reroute(ctx, session, reroute.InfoIncident); err != nil {
+		log.Println(err)
 	}
-	return res
+}
+
+// sendIncident sends a single incident to the session.
+func (m *Multicaster) sendIncident(ctx context.Context, sessionID string, incident *Incident, action string, distance *float64) {
+	incidentPayload := IncidentPayload{
+		Incident:      incident,
+		Action:        action,
+		RouteDistance: distance,
+	}
+	jsonPayload, _ := json.Marshal(incidentPayload)
+	m.Manager.Send(ctx, sessionID, ws.Message{
+		Type: "incident",
+		Data: jsonPayload,
+	})
 }
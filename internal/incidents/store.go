@@ -0,0 +1,129 @@
+package incidents
+
+import (
+	"sync"
+	"time"
+)
+
+// ActiveIncident is an incident of the store with the last action received for it.
+type ActiveIncident struct {
+	Incident *Incident
+	Action   Action
+	// LastUpdate is when the last message about the incident was received.
+	LastUpdate time.Time
+	// notified are the sessions the incident was sent to.
+	notified map[string]struct{}
+}
+
+// Retraction is an incident removed from the store, with the sessions it was sent to.
+type Retraction struct {
+	Incident *Incident
+	Sessions []string
+}
+
+// Store keeps the active incidents in memory, keyed by ID, with the sessions each one was sent to.
+// Every instance receives every incident message, so each one keeps its own store
+// and only knows about the notifications it sent.
+type Store struct {
+	mu        sync.RWMutex
+	incidents map[int64]*ActiveIncident
+}
+
+func NewStore() *Store {
+	return &Store{incidents: make(map[int64]*ActiveIncident)}
+}
+
+// Apply updates the store with an incident message: created and certified incidents are stored,
+// deleted ones are removed.
+// It returns the sessions the incident was already sent to, which must get the update,
+// and false if the incident was unknown.
+func (s *Store) Apply(incident *Incident, action Action, now time.Time) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active, known := s.incidents[incident.ID]
+	if !known {
+		active = &ActiveIncident{notified: make(map[string]struct{})}
+	}
+	notified := sessions(active.notified)
+
+	if action == Deleted {
+		delete(s.incidents, incident.ID)
+		return notified, known
+	}
+	active.Incident = incident
+	active.Action = action
+	active.LastUpdate = now
+	s.incidents[incident.ID] = active
+	return notified, known
+}
+
+// Load adds the incidents fetched at startup, without replacing the ones already received from the subscriber.
+func (s *Store) Load(incidents []Incident) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range incidents {
+		if _, ok := s.incidents[incidents[i].ID]; ok {
+			continue
+		}
+		lastUpdate := incidents[i].UpdatedAt
+		if lastUpdate.IsZero() {
+			lastUpdate = time.Now()
+		}
+		s.incidents[incidents[i].ID] = &ActiveIncident{
+			Incident:   &incidents[i],
+			Action:     Create,
+			LastUpdate: lastUpdate,
+			notified:   make(map[string]struct{}),
+		}
+	}
+}
+
+// MarkNotified records that the incident was sent to the session.
+func (s *Store) MarkNotified(incidentID int64, sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if active, ok := s.incidents[incidentID]; ok {
+		active.notified[sessionID] = struct{}{}
+	}
+}
+
+// Active returns the active incidents.
+func (s *Store) Active() []ActiveIncident {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]ActiveIncident, 0, len(s.incidents))
+	for _, active := range s.incidents {
+		res = append(res, ActiveIncident{Incident: active.Incident, Action: active.Action, LastUpdate: active.LastUpdate})
+	}
+	return res
+}
+
+// Expire removes the incidents without update for longer than their TTL, and returns them.
+// A zero TTL means the incident never expires.
+func (s *Store) Expire(now time.Time, ttl func(incident *Incident) time.Duration) []Retraction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []Retraction
+	for id, active := range s.incidents {
+		d := ttl(active.Incident)
+		if d <= 0 || now.Sub(active.LastUpdate) < d {
+			continue
+		}
+		delete(s.incidents, id)
+		res = append(res, Retraction{Incident: active.Incident, Sessions: sessions(active.notified)})
+	}
+	return res
+}
+
+func sessions(set map[string]struct{}) []string {
+	res := make([]string, 0, len(set))
+	for sessionID := range set {
+		res = append(res, sessionID)
+	}
+	return res
+}
@@ -1,6 +1,7 @@
 package incidents
 
 import (
+	"supmap-navigation/internal/gis"
 	"time"
 )
 
@@ -8,6 +9,15 @@ import (
 type IncidentPayload struct {
 	Incident *Incident `json:"incident"`
 	Action   string    `json:"action"`
+	// RouteDistance is the distance (in metres) along the route between the client and the incident.
+	// It is nil if the incident is not ahead of the client anymore (updates, deletions and expirations).
+	RouteDistance *float64 `json:"route_distance,omitempty"`
+}
+
+// IncidentsSnapshotPayload represents the payload of the "incidents_snapshot" messages,
+// listing the active incidents on a new route, nearest first.
+type IncidentsSnapshotPayload struct {
+	Incidents []IncidentPayload `json:"incidents"`
 }
 
 type Action string
@@ -16,6 +26,9 @@ const (
 	Create    Action = "create"
 	Certified Action = "certified"
 	Deleted   Action = "deleted"
+	// Expired is sent to the clients for the incidents without update for longer than their TTL.
+	// It is never received from supmap-incidents.
+	Expired Action = "expired"
 )
 
 func (a *Action) IsValid() bool {
@@ -35,6 +48,22 @@ type Incident struct {
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Heading is the direction of the traffic affected by the incident, in degrees clockwise from north.
+	Heading *float64 `json:"heading,omitempty"`
+	// AffectedPolyline is the short stretch of road affected by the incident, in the direction of the traffic.
+	// It gives the direction of the incident when Heading is not set.
+	AffectedPolyline []gis.Point `json:"affected_polyline,omitempty"`
+	// Geometry is the stretch or zone covered by the incident, if it is not a single point.
+	Geometry *Geometry `json:"geometry,omitempty"`
+}
+
+// Direction returns the direction of the traffic affected by the incident, in degrees clockwise from north.
+// It returns false if the incident affects both directions.
+func (i *Incident) Direction() (float64, bool) {
+	if i.Heading != nil {
+		return *i.Heading, true
+	}
+	return gis.PolylineBearing(i.AffectedPolyline)
 }
 
 type Type struct {
@@ -43,3 +72,17 @@ type Type struct {
 	Description       string `json:"description"`
 	NeedRecalculation bool   `json:"need_recalculation"`
 }
+
+// DetectedCongestion is the type of the incidents detected by the service from the speed of the sessions.
+// It has no ID: the incidents are submitted to supmap-incidents for review, not created directly.
+var DetectedCongestion = Type{
+	Name:        "detected_congestion",
+	Description: "Congestion detected from the speed of the drivers",
+}
+
+// SyntheticIncident is an incident detected by the service rather than reported by a user.
+type SyntheticIncident struct {
+	Incident
+	// Confidence is the confidence in the detection, between 0 and 1.
+	Confidence float64 `json:"confidence"`
+}
@@ -0,0 +1,31 @@
+package incidents
+
+import (
+	"supmap-navigation/internal/gis"
+	"testing"
+)
+
+func TestIncidentDirection(t *testing.T) {
+	heading := 180.0
+	eastward := []gis.Point{{Lat: 49.18, Lon: -0.37}, {Lat: 49.18, Lon: -0.36}}
+	tests := []struct {
+		name     string
+		incident Incident
+		want     float64
+		directed bool
+	}{
+		{name: "undirected", incident: Incident{}},
+		{name: "heading", incident: Incident{Heading: &heading}, want: 180, directed: true},
+		{name: "affected polyline", incident: Incident{AffectedPolyline: eastward}, want: 90, directed: true},
+		{name: "heading over polyline", incident: Incident{Heading: &heading, AffectedPolyline: eastward}, want: 180, directed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, directed := tt.incident.Direction()
+			if directed != tt.directed || (directed && gis.BearingDifference(got, tt.want) > 0.01) {
+				t.Errorf("got (%f, %v), want (%f, %v)", got, directed, tt.want, tt.directed)
+			}
+		})
+	}
+}
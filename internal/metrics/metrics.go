@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "navigation"
+
+var (
+	ConnectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_connected_clients",
+		Help:      "Number of WebSocket clients connected to this instance.",
+	})
+	MessagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_messages_sent_total",
+		Help:      "Number of WebSocket messages written to the clients, per message type.",
+	}, []string{"type"})
+	MessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_messages_received_total",
+		Help:      "Number of WebSocket messages received from the clients, per message type.",
+	}, []string{"type"})
+	SendBufferOverflows = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_send_buffer_overflows_total",
+		Help:      "Number of clients disconnected because their send buffer was full.",
+	})
+
+	IncidentMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "incident_messages_total",
+		Help:      "Number of incident messages processed, per action.",
+	}, []string{"action"})
+	IncidentMessagesRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "incident_messages_rejected_total",
+		Help:      "Number of incident messages rejected as dead letters.",
+	})
+	MulticastDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "multicast_duration_seconds",
+		Help:      "Time spent multicasting an incident to the sessions.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+	MulticastMatchedSessions = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "multicast_matched_sessions",
+		Help:      "Number of sessions whose route is impacted by a multicast incident.",
+		Buckets:   []float64{0, 1, 5, 10, 50, 100, 500, 1000},
+	})
+
+	GISRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "gis_request_duration_seconds",
+		Help:      "Latency of the requests to supmap-gis, per HTTP status (\"error\" if no response).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"status"})
+
+	CacheErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_errors_total",
+		Help:      "Number of Redis session cache errors, per operation.",
+	}, []string{"operation"})
+
+	TrafficObservations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "traffic_observations_total",
+		Help:      "Number of speeds observed on road segments from the positions of the clients.",
+	})
+	CongestionDetections = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "congestion_detections_total",
+		Help:      "Number of congestions detected and published as synthetic incidents.",
+	})
+)
@@ -0,0 +1,26 @@
+package navigation
+
+import (
+	"context"
+	"supmap-navigation/internal/gis"
+	"time"
+)
+
+// BreadcrumbStore records the positions driven during the sessions, kept after the sessions are over
+// to investigate the trips afterwards.
+type BreadcrumbStore interface {
+	Append(ctx context.Context, sessionID string, position Position) error
+	// List returns the recorded positions of the session, oldest first.
+	List(ctx context.Context, sessionID string) ([]Position, error)
+}
+
+// NeedsBreadcrumb returns true if the position is worth recording after the last recorded one:
+// the client moved by at least minDistance metres, or minInterval elapsed.
+// This downsamples the positions of a slow or stopped client.
+func NeedsBreadcrumb(last *Position, position Position, minDistance float64, minInterval time.Duration) bool {
+	if last == nil {
+		return true
+	}
+	distance := gis.Haversine(gis.Point{Lat: last.Lat, Lon: last.Lon}, gis.Point{Lat: position.Lat, Lon: position.Lon})
+	return distance >= minDistance || position.Timestamp.Sub(last.Timestamp) >= minInterval
+}
@@ -0,0 +1,28 @@
+package navigation
+
+import "supmap-navigation/internal/gis"
+
+// Announcement records the last "maneuver" message sent to a client.
+type Announcement struct {
+	ManeuverIndex int `json:"maneuver_index"`
+	// Threshold is the announce distance (in metres) crossed when the message was sent.
+	Threshold float64 `json:"threshold"`
+}
+
+// NextManeuver returns the index of the first maneuver starting ahead of the given distance along the route,
+// and the distance (in metres) left to reach it.
+// It returns false if every maneuver has already been passed.
+func (r Route) NextManeuver(distanceAlong float64) (int, float64, bool) {
+	if len(r.Polyline) == 0 {
+		return 0, 0, false
+	}
+
+	cumulative := gis.CumulativeDistances(r.GISPolyline())
+	for i, m := range r.Maneuvers {
+		begin := cumulative[clampIndex(int(m.BeginShapeIndex), len(cumulative))]
+		if begin > distanceAlong {
+			return i, begin - distanceAlong, true
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,92 @@
+package navigation
+
+import (
+	"supmap-navigation/internal/gis"
+	"time"
+)
+
+// Progress represents how far along its route a client is.
+type Progress struct {
+	// ShapeIndex is the index of the polyline point starting the segment the client is on.
+	ShapeIndex int `json:"shape_index"`
+	// DistanceAlong is the distance (in metres) travelled along the route.
+	DistanceAlong float64 `json:"distance_along"`
+	// RemainingDistance is the distance (in metres) left to the destination.
+	RemainingDistance float64 `json:"remaining_distance"`
+	// RemainingTime is the time (in seconds) left to the destination.
+	// It is only known when the route carries its maneuvers.
+	RemainingTime *float64   `json:"remaining_time,omitempty"`
+	ETA           *time.Time `json:"eta,omitempty"`
+}
+
+// ComputeProgress snaps the position onto the route polyline and computes the remaining distance and time.
+// Maneuvers Time and Length are used when available, otherwise the remaining distance is measured on the polyline.
+// It returns nil if the route has no polyline.
+func ComputeProgress(route Route, position Position, now time.Time) *Progress {
+	polyline := route.GISPolyline()
+	projection, ok := gis.ProjectOnPolyline(gis.Point{Lat: position.Lat, Lon: position.Lon}, polyline)
+	if !ok {
+		return nil
+	}
+
+	cumulative := gis.CumulativeDistances(polyline)
+	along := projection.DistanceAlong(cumulative)
+
+	progress := &Progress{
+		ShapeIndex:        projection.SegmentIndex,
+		DistanceAlong:     along,
+		RemainingDistance: cumulative[len(cumulative)-1] - along,
+	}
+
+	if len(route.Maneuvers) == 0 {
+		return progress
+	}
+
+	var remainingTime, remainingDistance float64
+	for _, m := range route.Maneuvers {
+		begin := cumulative[clampIndex(int(m.BeginShapeIndex), len(cumulative))]
+		end := cumulative[clampIndex(int(m.EndShapeIndex), len(cumulative))]
+		switch {
+		case along >= end:
+			// Maneuver already done.
+			continue
+		case along <= begin:
+			remainingTime += m.Time
+			remainingDistance += m.Length * 1000
+		default:
+			ratio := (end - along) / (end - begin)
+			remainingTime += ratio * m.Time
+			remainingDistance += ratio * m.Length * 1000
+		}
+	}
+
+	eta := now.Add(time.Duration(remainingTime * float64(time.Second)))
+	progress.RemainingTime = &remainingTime
+	progress.RemainingDistance = remainingDistance
+	progress.ETA = &eta
+	return progress
+}
+
+// PolylineAhead returns the part of the route polyline ahead of the position.
+// It starts at the projection of the position onto the polyline.
+func (r Route) PolylineAhead(position Position) []gis.Point {
+	polyline := r.GISPolyline()
+	projection, ok := gis.ProjectOnPolyline(gis.Point{Lat: position.Lat, Lon: position.Lon}, polyline)
+	if !ok || len(polyline) == 1 {
+		return polyline
+	}
+
+	i := projection.SegmentIndex
+	start := gis.Interpolate(polyline[i], polyline[i+1], projection.Fraction)
+	return append([]gis.Point{start}, polyline[i+1:]...)
+}
+
+func clampIndex(i, length int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= length {
+		return length - 1
+	}
+	return i
+}
@@ -2,14 +2,77 @@ package navigation
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"supmap-navigation/internal/gis"
+	routing "supmap-navigation/internal/gis/routing"
 	"time"
 )
 
 type Session struct {
-	ID           string    `json:"session_id"`
+	ID string `json:"session_id"`
+	// UserID is the user authenticated when the session was created, only this user can connect to it.
+	UserID       string    `json:"user_id,omitempty"`
 	LastPosition Position  `json:"last_position"`
 	Route        Route     `json:"route"`
 	UpdatedAt    time.Time `json:"updated_at"`
+	// Costing, CostingOptions and Language are the routing preferences chosen by the client,
+	// reused for every route recalculation.
+	Costing        routing.Costing         `json:"costing"`
+	CostingOptions *routing.CostingOptions `json:"costing_options,omitempty"`
+	Language       *string                 `json:"language,omitempty"`
+	// ChooseRoute is true if the client wants to pick its route among alternatives on recalculations.
+	ChooseRoute bool `json:"choose_route"`
+	// PendingRoutes are the routes proposed to the client, waiting for its choice.
+	PendingRoutes *PendingRoutes `json:"pending_routes,omitempty"`
+	// OffRouteCount is the number of consecutive positions received away from the route.
+	OffRouteCount int `json:"off_route_count"`
+	// Progress is the progress computed from the last position.
+	Progress *Progress `json:"progress,omitempty"`
+	// ProgressSentAt is the last time a "progress" message was sent to the client.
+	ProgressSentAt time.Time `json:"progress_sent_at"`
+	// LastAnnouncement is the last maneuver announced to the client on the current route.
+	LastAnnouncement *Announcement `json:"last_announcement,omitempty"`
+	// LastBreadcrumb is the last position recorded in the breadcrumb trail of the session.
+	LastBreadcrumb *Position `json:"last_breadcrumb,omitempty"`
+	Trip           Trip      `json:"trip"`
+}
+
+// PendingRoutes are routes proposed to a client after a recalculation.
+// The fastest one is committed if the client didn't choose before ExpiresAt.
+type PendingRoutes struct {
+	Routes    []routing.Route `json:"routes"`
+	Info      string          `json:"info"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// Trip holds the statistics of the trip, sent to the client on arrival.
+type Trip struct {
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// DistanceDriven is the distance (in metres) between the successive positions of the client.
+	DistanceDriven float64 `json:"distance_driven"`
+	Reroutes       int     `json:"reroutes"`
+}
+
+// ValidateRouting checks the routing preferences of the session.
+// The costing defaults to "auto" when the client didn't choose one.
+func (s *Session) ValidateRouting() error {
+	if s.Costing == "" {
+		s.Costing = routing.CostingAuto
+	}
+	if !s.Costing.IsValid() {
+		return fmt.Errorf("costing %q is invalid", s.Costing)
+	}
+	if s.CostingOptions != nil {
+		return s.CostingOptions.Validate()
+	}
+	return nil
+}
+
+// IsCompleted returns true once the client has arrived at its destination.
+func (s *Session) IsCompleted() bool {
+	return s.Trip.CompletedAt != nil
 }
 
 type Position struct {
@@ -31,8 +94,48 @@ type Location struct {
 type Route struct {
 	Polyline  []Point    `json:"polyline"`
 	Locations []Location `json:"locations"`
+	Maneuvers []Maneuver `json:"maneuvers,omitempty"`
 }
 
+// Maneuver is a single instruction of the route.
+// Shape indexes refer to the route polyline.
+type Maneuver struct {
+	Type            uint8    `json:"type"`
+	Instruction     string   `json:"instruction"`
+	StreetNames     []string `json:"street_names"`
+	Time            float64  `json:"time"`   // seconds
+	Length          float64  `json:"length"` // kilometres
+	BeginShapeIndex uint     `json:"begin_shape_index"`
+	EndShapeIndex   uint     `json:"end_shape_index"`
+}
+
+// GISPolyline returns the route polyline as gis points.
+func (r Route) GISPolyline() []gis.Point {
+	res := make([]gis.Point, len(r.Polyline))
+	for i, p := range r.Polyline {
+		res[i] = gis.Point{Lat: p.Lat, Lon: p.Lon}
+	}
+	return res
+}
+
+// Waypoints returns the intermediate locations of the route, between the origin and the destination.
+func (r Route) Waypoints() []Location {
+	if len(r.Locations) <= 2 {
+		return nil
+	}
+	return r.Locations[1 : len(r.Locations)-1]
+}
+
+// Destination returns the last location of the route.
+func (r Route) Destination() (Location, bool) {
+	if len(r.Locations) == 0 {
+		return Location{}, false
+	}
+	return r.Locations[len(r.Locations)-1], true
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
 type SessionCache interface {
 	SetSession(ctx context.Context, session *Session) error
 	GetSession(ctx context.Context, sessionID string) (*Session, error)
@@ -0,0 +1,13 @@
+package navigation
+
+import (
+	"context"
+)
+
+// TripIncidentStore records the incidents sent to the sessions during their current trip, for the arrival summary.
+// It is kept apart from the session so that recording an incident never overwrites a concurrent update of the session.
+type TripIncidentStore interface {
+	Add(ctx context.Context, session *Session, incidentID int64) error
+	// Count returns the number of distinct incidents sent during the current trip of the session.
+	Count(ctx context.Context, session *Session) (int, error)
+}
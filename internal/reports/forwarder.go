@@ -0,0 +1,154 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"net/http"
+	"supmap-navigation/internal/incidents"
+	"sync"
+	"time"
+)
+
+// Report is an incident reported by a user while driving.
+type Report struct {
+	UserID string  `json:"-"`
+	TypeID int64   `json:"type_id"`
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+}
+
+// Vote is the confirmation (or denial) by a user that an incident is still there.
+type Vote struct {
+	UserID       string `json:"-"`
+	IncidentID   int64  `json:"-"`
+	StillPresent bool   `json:"is_still_present"`
+}
+
+// Forwarder forwards the reports and votes of the users to supmap-incidents.
+type Forwarder interface {
+	// Report creates the incident, or adds the report to the same incident nearby, and returns it.
+	Report(ctx context.Context, report Report) (*incidents.Incident, error)
+	Vote(ctx context.Context, vote Vote) error
+}
+
+// HTTPForwarder is the Forwarder calling the supmap-incidents API.
+// The service authenticates with its own token and acts on behalf of the user given in the X-User-ID header.
+type HTTPForwarder struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+func NewHTTPForwarder(baseURL string, token string, timeout time.Duration) *HTTPForwarder {
+	return &HTTPForwarder{
+		baseURL:    baseURL,
+		token:      token,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (f *HTTPForwarder) Report(ctx context.Context, report Report) (*incidents.Incident, error) {
+	var res struct {
+		Data incidents.Incident `json:"data"`
+	}
+	if err := f.post(ctx, "/incidents", report.UserID, report, &res); err != nil {
+		return nil, err
+	}
+	return &res.Data, nil
+}
+
+func (f *HTTPForwarder) Vote(ctx context.Context, vote Vote) error {
+	return f.post(ctx, fmt.Sprintf("/incidents/%d/interactions", vote.IncidentID), vote.UserID, vote, nil)
+}
+
+func (f *HTTPForwarder) post(ctx context.Context, path string, userID string, body any, res any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", userID)
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if res == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// MemoryForwarder is a fake Forwarder keeping the reports and votes in memory,
+// for local development without supmap-incidents.
+type MemoryForwarder struct {
+	mu      sync.Mutex
+	nextID  int64
+	reports []Report
+	votes   []Vote
+}
+
+func NewMemoryForwarder() *MemoryForwarder {
+	return &MemoryForwarder{nextID: 1}
+}
+
+func (f *MemoryForwarder) Report(_ context.Context, report Report) (*incidents.Incident, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reports = append(f.reports, report)
+	now := time.Now()
+	incident := &incidents.Incident{
+		ID:        f.nextID,
+		Type:      &incidents.Type{ID: report.TypeID},
+		Lat:       report.Lat,
+		Lon:       report.Lon,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	f.nextID++
+	return incident, nil
+}
+
+func (f *MemoryForwarder) Vote(_ context.Context, vote Vote) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.votes = append(f.votes, vote)
+	return nil
+}
+
+// Reports returns the reports forwarded so far.
+func (f *MemoryForwarder) Reports() []Report {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Report(nil), f.reports...)
+}
+
+// Votes returns the votes forwarded so far.
+func (f *MemoryForwarder) Votes() []Vote {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Vote(nil), f.votes...)
+}
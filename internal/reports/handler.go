@@ -0,0 +1,205 @@
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"supmap-navigation/internal/config"
+	"supmap-navigation/internal/gis"
+	"supmap-navigation/internal/navigation"
+	"supmap-navigation/internal/ws"
+	"time"
+)
+
+// Reasons of the rejected reports and votes, sent in the "ack" messages.
+const (
+	ReasonInvalidMessage = "invalid_message"
+	ReasonInvalidType    = "invalid_type"
+	ReasonNoPosition     = "no_position"
+	ReasonTooFar         = "too_far"
+	ReasonRateLimited    = "rate_limited"
+	ReasonForwardFailed  = "forward_failed"
+	ReasonDisabled       = "disabled"
+)
+
+// ReportIncidentData represents the data of the "report_incident" messages sent by the clients.
+type ReportIncidentData struct {
+	// RequestID is chosen by the client to match the "ack" with its message.
+	RequestID string  `json:"request_id"`
+	TypeID    int64   `json:"type_id"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+}
+
+// VoteIncidentData represents the data of the "vote_incident" messages sent by the clients.
+type VoteIncidentData struct {
+	RequestID    string `json:"request_id"`
+	IncidentID   int64  `json:"incident_id"`
+	StillPresent *bool  `json:"still_present"`
+}
+
+// AckPayload represents the payload of the "ack" messages sent in response to the reports and votes.
+type AckPayload struct {
+	RequestID string `json:"request_id"`
+	// Type is the type of the acknowledged message.
+	Type     string `json:"type"`
+	Accepted bool   `json:"accepted"`
+	// Reason is set when the message is rejected.
+	Reason string `json:"reason,omitempty"`
+	// IncidentID is the incident the report was added to.
+	IncidentID *int64 `json:"incident_id,omitempty"`
+}
+
+// RateLimiter counts the actions per key over fixed windows.
+type RateLimiter interface {
+	// Allow counts an action for the key and returns false if more than limit actions were made in the current window.
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error)
+}
+
+// Handler handles the incidents reported and voted by the clients while driving.
+type Handler struct {
+	config       *config.Config
+	logger       *slog.Logger
+	sessionCache navigation.SessionCache
+	// forwarder forwards the reports and votes, nil if they are disabled.
+	forwarder Forwarder
+	limiter   RateLimiter
+}
+
+func NewHandler(config *config.Config, logger *slog.Logger, sessionCache navigation.SessionCache, forwarder Forwarder, limiter RateLimiter) *Handler {
+	return &Handler{
+		config:       config,
+		logger:       logger,
+		sessionCache: sessionCache,
+		forwarder:    forwarder,
+		limiter:      limiter,
+	}
+}
+
+// HandleReport handles the "report_incident" messages.
+// The report must have an accepted type and be close to the last position of the client.
+func (h *Handler) HandleReport(ctx context.Context, client *ws.Client, msg ws.Message) {
+	var data ReportIncidentData
+	if err := json.Unmarshal(msg.Data, &data); err != nil {
+		h.logger.Warn("failed to unmarshal incident report", "clientID", client.ID, "error", err)
+		h.ack(ctx, client, AckPayload{Type: msg.Type, Reason: ReasonInvalidMessage})
+		return
+	}
+	ack := AckPayload{RequestID: data.RequestID, Type: msg.Type}
+	if h.forwarder == nil {
+		ack.Reason = ReasonDisabled
+		h.ack(ctx, client, ack)
+		return
+	}
+
+	if data.TypeID <= 0 || (len(h.config.ReportTypeIDs) > 0 && !slices.Contains(h.config.ReportTypeIDs, data.TypeID)) {
+		ack.Reason = ReasonInvalidType
+		h.ack(ctx, client, ack)
+		return
+	}
+	if data.Lat < -90 || data.Lat > 90 || data.Lon < -180 || data.Lon > 180 {
+		ack.Reason = ReasonInvalidMessage
+		h.ack(ctx, client, ack)
+		return
+	}
+
+	session, err := h.sessionCache.GetSession(ctx, client.ID)
+	if err != nil {
+		h.logger.Warn("failed to get session for incident report", "clientID", client.ID, "error", err)
+		ack.Reason = ReasonNoPosition
+		h.ack(ctx, client, ack)
+		return
+	}
+	if session.LastPosition.Lat == 0 && session.LastPosition.Lon == 0 {
+		ack.Reason = ReasonNoPosition
+		h.ack(ctx, client, ack)
+		return
+	}
+	last := gis.Point{Lat: session.LastPosition.Lat, Lon: session.LastPosition.Lon}
+	if gis.Haversine(last, gis.Point{Lat: data.Lat, Lon: data.Lon}) > h.config.ReportMaxDistance {
+		ack.Reason = ReasonTooFar
+		h.ack(ctx, client, ack)
+		return
+	}
+
+	if !h.allow(ctx, client, msg.Type) {
+		ack.Reason = ReasonRateLimited
+		h.ack(ctx, client, ack)
+		return
+	}
+
+	incident, err := h.forwarder.Report(ctx, Report{UserID: client.UserID, TypeID: data.TypeID, Lat: data.Lat, Lon: data.Lon})
+	if err != nil {
+		h.logger.Warn("failed to forward incident report", "clientID", client.ID, "error", err)
+		ack.Reason = ReasonForwardFailed
+		h.ack(ctx, client, ack)
+		return
+	}
+	ack.Accepted = true
+	ack.IncidentID = &incident.ID
+	h.ack(ctx, client, ack)
+}
+
+// HandleVote handles the "vote_incident" messages.
+func (h *Handler) HandleVote(ctx context.Context, client *ws.Client, msg ws.Message) {
+	var data VoteIncidentData
+	if err := json.Unmarshal(msg.Data, &data); err != nil {
+		h.logger.Warn("failed to unmarshal incident vote", "clientID", client.ID, "error", err)
+		h.ack(ctx, client, AckPayload{Type: msg.Type, Reason: ReasonInvalidMessage})
+		return
+	}
+	ack := AckPayload{RequestID: data.RequestID, Type: msg.Type}
+	if h.forwarder == nil {
+		ack.Reason = ReasonDisabled
+		h.ack(ctx, client, ack)
+		return
+	}
+
+	if data.IncidentID <= 0 || data.StillPresent == nil {
+		ack.Reason = ReasonInvalidMessage
+		h.ack(ctx, client, ack)
+		return
+	}
+
+	if !h.allow(ctx, client, msg.Type) {
+		ack.Reason = ReasonRateLimited
+		h.ack(ctx, client, ack)
+		return
+	}
+
+	if err := h.forwarder.Vote(ctx, Vote{UserID: client.UserID, IncidentID: data.IncidentID, StillPresent: *data.StillPresent}); err != nil {
+		h.logger.Warn("failed to forward incident vote", "clientID", client.ID, "error", err)
+		ack.Reason = ReasonForwardFailed
+		h.ack(ctx, client, ack)
+		return
+	}
+	ack.Accepted = true
+	ack.IncidentID = &data.IncidentID
+	h.ack(ctx, client, ack)
+}
+
+// allow applies the rate limit of the session for the message type.
+// The message is let through if the rate limiter fails, rather than blocking every report.
+func (h *Handler) allow(ctx context.Context, client *ws.Client, msgType string) bool {
+	key := fmt.Sprintf("%s:%s", msgType, client.ID)
+	ok, err := h.limiter.Allow(ctx, key, h.config.ReportRateLimit, h.config.ReportRateWindow)
+	if err != nil {
+		h.logger.Warn("failed to apply rate limit", "clientID", client.ID, "error", err)
+		return true
+	}
+	return ok
+}
+
+func (h *Handler) ack(ctx context.Context, client *ws.Client, ack AckPayload) {
+	payload, err := json.Marshal(ack)
+	if err != nil {
+		h.logger.Warn("failed to marshal ack", "clientID", client.ID, "error", err)
+		return
+	}
+	client.Manager.Send(ctx, client.ID, ws.Message{
+		Type: "ack",
+		Data: payload,
+	})
+}
@@ -0,0 +1,273 @@
+package reroute
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"supmap-navigation/internal/config"
+	routing "supmap-navigation/internal/gis/routing"
+	"supmap-navigation/internal/navigation"
+	"supmap-navigation/internal/tracing"
+	"supmap-navigation/internal/ws"
+	"time"
+)
+
+var tracer = tracing.Tracer("supmap-navigation/internal/reroute")
+
+// Reasons sent in the "info" field of a "route" message.
+const (
+	InfoIncident = "recalculated_due_to_incident"
+	InfoOffRoute = "recalculated_off_route"
+)
+
+// RoutePayload represents the payload of the "route" messages sent to the clients.
+type RoutePayload struct {
+	Route *routing.Route `json:"route"`
+	Info  string         `json:"info"`
+}
+
+// RouteOptionsPayload represents the payload of the "route_options" messages sent to the clients.
+type RouteOptionsPayload struct {
+	Options   []RouteOption `json:"options"`
+	Info      string        `json:"info"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+type RouteOption struct {
+	Index   int             `json:"index"`
+	Route   *routing.Route  `json:"route"`
+	Summary routing.Summary `json:"summary"`
+	// ExtraTime is the difference (in seconds) between the option and the remaining time on the current route.
+	// It is negative when the option is faster, and absent if the remaining time is unknown.
+	ExtraTime *float64 `json:"extra_time,omitempty"`
+}
+
+// RouteChoice represents the data of the "route_choice" messages sent by the clients.
+type RouteChoice struct {
+	Index int `json:"index"`
+}
+
+type Rerouter struct {
+	Config        *config.Config
+	Logger        *slog.Logger
+	Manager       *ws.Manager
+	RoutingClient *routing.Client
+	SessionCache  navigation.SessionCache
+}
+
+func NewRerouter(config *config.Config, logger *slog.Logger, manager *ws.Manager, routingClient *routing.Client, sessionCache navigation.SessionCache) *Rerouter {
+	return &Rerouter{
+		Config:        config,
+		Logger:        logger,
+		Manager:       manager,
+		RoutingClient: routingClient,
+		SessionCache:  sessionCache,
+	}
+}
+
+// Reroute calculates a new route from the session's last position, updates the session route
+// and sends the new route to the session.
+// If the client chose to pick its routes, the alternatives are proposed instead, see HandleRouteChoice.
+// The session is not saved in the cache, it is up to the caller.
+func (r *Rerouter) Reroute(ctx context.Context, session *navigation.Session, info string) (err error) {
+	ctx, span := tracer.Start(ctx, "reroute.Reroute", trace.WithAttributes(
+		attribute.String("session.id", session.ID),
+		attribute.String("reroute.info", info),
+	))
+	defer func() {
+		if err != nil {
+			tracing.Fail(span, err)
+		}
+		span.End()
+	}()
+
+	if len(session.Route.Locations) < 2 {
+		return errors.New("session route has less than 2 locations")
+	}
+
+	session.Route.Locations[0] = navigation.Location{
+		Lat: session.LastPosition.Lat,
+		Lon: session.LastPosition.Lon,
+	}
+
+	alternates := 0
+	if session.ChooseRoute {
+		alternates = r.Config.RouteAlternates
+	}
+	req := routing.RouteRequest{
+		Locations:      convertLocationsToLocationRequests(session.Route.Locations),
+		Costing:        session.Costing,
+		CostingOptions: session.CostingOptions,
+		Language:       session.Language,
+		Alternates:     &alternates,
+	}
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("invalid route request: %w", err)
+	}
+
+	routes, err := r.RoutingClient.CalculateRoutes(ctx, req)
+	if err != nil {
+		return fmt.Errorf("calculating route: %w", err)
+	}
+
+	if session.ChooseRoute && len(routes) > 1 {
+		return r.proposeRoutes(ctx, session, routes, info)
+	}
+	return r.commitRoute(ctx, session, &routes[0], info)
+}
+
+// HandleRouteChoice handles the "route_choice" messages, committing the route picked by the client
+// among the ones previously proposed.
+func (r *Rerouter) HandleRouteChoice(ctx context.Context, client *ws.Client, msg ws.Message) {
+	var choice RouteChoice
+	if err := json.Unmarshal(msg.Data, &choice); err != nil {
+		r.Logger.Warn("failed to unmarshal route choice", "clientID", client.ID, "error", err)
+		return
+	}
+
+	session, err := r.SessionCache.GetSession(ctx, client.ID)
+	if err != nil {
+		r.Logger.Warn("failed to get session for route choice", "clientID", client.ID, "error", err)
+		return
+	}
+
+	pending := session.PendingRoutes
+	if pending == nil {
+		r.Logger.Warn("route choice without pending routes", "clientID", client.ID)
+		return
+	}
+	if choice.Index < 0 || choice.Index >= len(pending.Routes) {
+		r.Logger.Warn("invalid route choice", "clientID", client.ID, "index", choice.Index)
+		return
+	}
+
+	if err := r.commitRoute(ctx, session, &pending.Routes[choice.Index], pending.Info); err != nil {
+		r.Logger.Warn("failed to commit chosen route", "clientID", client.ID, "error", err)
+		return
+	}
+	if err := r.SessionCache.SetSession(ctx, session); err != nil {
+		r.Logger.Warn("failed to save session to cache", "clientID", client.ID, "error", err)
+	}
+}
+
+// CommitExpiredRoutes commits the fastest proposed route if the client didn't choose one in time.
+// The session is not saved in the cache, it is up to the caller.
+func (r *Rerouter) CommitExpiredRoutes(ctx context.Context, session *navigation.Session, now time.Time) error {
+	pending := session.PendingRoutes
+	if pending == nil || now.Before(pending.ExpiresAt) {
+		return nil
+	}
+
+	fastest := 0
+	for i, route := range pending.Routes {
+		if route.Summary.Time < pending.Routes[fastest].Summary.Time {
+			fastest = i
+		}
+	}
+	return r.commitRoute(ctx, session, &pending.Routes[fastest], pending.Info)
+}
+
+// proposeRoutes stores the routes as pending in the session and sends them to the session.
+func (r *Rerouter) proposeRoutes(ctx context.Context, session *navigation.Session, routes []routing.Route, info string) error {
+	now := time.Now()
+	session.PendingRoutes = &navigation.PendingRoutes{
+		Routes:    routes,
+		Info:      info,
+		ExpiresAt: now.Add(r.Config.RouteChoiceTimeout),
+	}
+	session.OffRouteCount = 0
+	session.UpdatedAt = now
+
+	options := make([]RouteOption, len(routes))
+	for i := range routes {
+		options[i] = RouteOption{
+			Index:   i,
+			Route:   &routes[i],
+			Summary: routes[i].Summary,
+		}
+		if session.Progress != nil && session.Progress.RemainingTime != nil {
+			extra := routes[i].Summary.Time - *session.Progress.RemainingTime
+			options[i].ExtraTime = &extra
+		}
+	}
+
+	payload, err := json.Marshal(RouteOptionsPayload{
+		Options:   options,
+		Info:      info,
+		ExpiresAt: session.PendingRoutes.ExpiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling route options payload: %w", err)
+	}
+	r.Manager.Send(ctx, session.ID, ws.Message{
+		Type: "route_options",
+		Data: payload,
+	})
+	return nil
+}
+
+// commitRoute makes the route the new route of the session and sends it to the session.
+func (r *Rerouter) commitRoute(ctx context.Context, session *navigation.Session, route *routing.Route, info string) error {
+	session.Route.Polyline, session.Route.Maneuvers = flattenLegs(route.Legs)
+	session.PendingRoutes = nil
+	session.Progress = nil
+	session.LastAnnouncement = nil
+	session.Trip.Reroutes++
+	session.OffRouteCount = 0
+	session.UpdatedAt = time.Now()
+	r.Manager.IndexRoute(session)
+
+	payload, err := json.Marshal(RoutePayload{
+		Route: route,
+		Info:  info,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling route payload: %w", err)
+	}
+	r.Manager.Send(ctx, session.ID, ws.Message{
+		Type: "route",
+		Data: payload,
+	})
+	r.Manager.RouteChanged(ctx, session)
+	return nil
+}
+
+// flattenLegs concatenates the legs shapes into a single polyline,
+// and shifts the maneuvers shape indexes accordingly.
+func flattenLegs(legs []routing.Leg) ([]navigation.Point, []navigation.Maneuver) {
+	var polyline []navigation.Point
+	var maneuvers []navigation.Maneuver
+	for _, leg := range legs {
+		offset := uint(len(polyline))
+		for _, m := range leg.Maneuvers {
+			maneuvers = append(maneuvers, navigation.Maneuver{
+				Type:            m.Type,
+				Instruction:     m.Instruction,
+				StreetNames:     m.StreetNames,
+				Time:            m.Time,
+				Length:          m.Length,
+				BeginShapeIndex: m.BeginShapeIndex + offset,
+				EndShapeIndex:   m.EndShapeIndex + offset,
+			})
+		}
+		for _, p := range leg.Shape {
+			polyline = append(polyline, navigation.Point{Lat: p.Lat, Lon: p.Lon})
+		}
+	}
+	return polyline, maneuvers
+}
+
+func convertLocationsToLocationRequests(points []navigation.Location) []routing.LocationRequest {
+	res := make([]routing.LocationRequest, len(points))
+	for i, p := range points {
+		res[i] = routing.LocationRequest{
+			Lat: p.Lat,
+			Lon: p.Lon,
+		}
+	}
+	return res
+}
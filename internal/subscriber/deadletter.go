@@ -0,0 +1,44 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"supmap-navigation/internal/metrics"
+	"time"
+)
+
+// DeadLetter is an incident message rejected by the subscriber, kept to be inspected and re-injected.
+type DeadLetter struct {
+	ID string `json:"id"`
+	// Source is the channel or stream the message was read from.
+	Source     string    `json:"source"`
+	Payload    string    `json:"payload"`
+	Reason     string    `json:"reason"`
+	RejectedAt time.Time `json:"rejected_at"`
+}
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+type DeadLetterStore interface {
+	// Add stores a rejected message, its ID is set by the store.
+	Add(ctx context.Context, letter DeadLetter) error
+	// List returns the last count rejected messages, most recent first.
+	List(ctx context.Context, count int64) ([]DeadLetter, error)
+	Get(ctx context.Context, id string) (*DeadLetter, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// rejectMessage stores a message that couldn't be handled in the dead-letter store.
+// A nil store drops the message.
+func rejectMessage(ctx context.Context, store DeadLetterStore, source, payload string, reason error) error {
+	metrics.IncidentMessagesRejected.Inc()
+	if store == nil {
+		return nil
+	}
+	return store.Add(ctx, DeadLetter{
+		Source:     source,
+		Payload:    payload,
+		Reason:     reason.Error(),
+		RejectedAt: time.Now(),
+	})
+}
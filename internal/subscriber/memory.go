@@ -0,0 +1,79 @@
+package subscriber
+
+import (
+	"context"
+	"log/slog"
+)
+
+// MemorySource is an in-memory IncidentSource, for the tests and the local development without supmap-incidents.
+// The incidents are only those sent with Publish.
+type MemorySource struct {
+	logger        *slog.Logger
+	messages      chan string
+	maxConcurrent int
+	handler       IncidentHandler
+	deadLetters   DeadLetterStore
+}
+
+func NewMemorySource(logger *slog.Logger, buffer int, maxConcurrent int, handler IncidentHandler, deadLetters DeadLetterStore) *MemorySource {
+	return &MemorySource{
+		logger:        logger,
+		messages:      make(chan string, buffer),
+		maxConcurrent: maxConcurrent,
+		handler:       handler,
+		deadLetters:   deadLetters,
+	}
+}
+
+func (s *MemorySource) Start(ctx context.Context) error {
+	s.logger.Info("in-memory incident source is running")
+
+	// Same improvised semaphore as the Pub/Sub subscriber.
+	sem := make(chan struct{}, s.maxConcurrent)
+	for {
+		select {
+		case payload := <-s.messages:
+			select {
+			case sem <- struct{}{}:
+				go func() {
+					defer func() { <-sem }()
+					if err := s.handleMessage(ctx, payload); err != nil {
+						s.logger.Error("error handling message", "error", err)
+					}
+				}()
+			case <-ctx.Done():
+				return nil
+			}
+		case <-ctx.Done():
+			s.logger.Info("shutting down in-memory incident source")
+			return nil
+		}
+	}
+}
+
+// Publish queues the message, it blocks while the buffer is full.
+func (s *MemorySource) Publish(ctx context.Context, payload string) error {
+	select {
+	case s.messages <- payload:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *MemorySource) handleMessage(ctx context.Context, payload string) error {
+	incidentMsg, err := decodeIncidentMessage(payload)
+	if err != nil {
+		if dlErr := rejectMessage(ctx, s.deadLetters, "memory", payload, err); dlErr != nil {
+			s.logger.Error("failed to store dead letter", "error", dlErr)
+		}
+		return err
+	}
+
+	s.logger.Debug("in-memory incident message received", "incidentMsg", incidentMsg)
+
+	ctx, span := startIncidentSpan(ctx, incidentMsg, "memory")
+	defer span.End()
+	s.handler.MulticastIncident(ctx, &incidentMsg.Data, string(incidentMsg.Action))
+	return nil
+}
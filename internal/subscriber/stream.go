@@ -0,0 +1,288 @@
+package subscriber
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"strings"
+	"supmap-navigation/internal/config"
+	"sync"
+	"time"
+)
+
+// streamPayloadField is the field of the stream entries holding the incident message.
+const streamPayloadField = "payload"
+
+// streamBlock is how long a read waits for new entries, so that the pending entries are reclaimed regularly.
+const streamBlock = 5 * time.Second
+
+// fanoutMessage is an incident consumed from the stream by an instance and forwarded to the others.
+type fanoutMessage struct {
+	Origin  string `json:"origin"`
+	Payload string `json:"payload"`
+}
+
+// StreamSubscriber is the Redis Streams IncidentSource.
+// It reads the incidents from a stream as a member of a consumer group,
+// so that the incidents published while no instance is running are not lost.
+//
+// An entry is acknowledged once it has been handled, the entries left pending by a dead consumer
+// are reclaimed after config.StreamClaimMinIdle.
+// Since an entry is delivered to a single instance of the group, it is forwarded to the other instances
+// through Pub/Sub: every instance only follows the sessions connected to it.
+type StreamSubscriber struct {
+	config        *config.Config
+	logger        *slog.Logger
+	client        *redis.Client
+	stream        string
+	group         string
+	consumer      string
+	maxConcurrent int
+	handler       IncidentHandler
+	deadLetters   DeadLetterStore
+}
+
+func NewStreamSubscriber(config *config.Config, logger *slog.Logger, client *redis.Client, stream string, maxConcurrent int, handler IncidentHandler, deadLetters DeadLetterStore) *StreamSubscriber {
+	return &StreamSubscriber{
+		config:        config,
+		logger:        logger,
+		client:        client,
+		stream:        stream,
+		group:         config.RedisIncidentsGroup,
+		consumer:      config.InstanceID,
+		maxConcurrent: maxConcurrent,
+		handler:       handler,
+		deadLetters:   deadLetters,
+	}
+}
+
+func (s *StreamSubscriber) Start(ctx context.Context) error {
+	err := s.client.XGroupCreateMkStream(ctx, s.stream, s.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group: %w", err)
+	}
+	s.logger.Info("Redis stream subscriber is running", "stream", s.stream, "group", s.group, "consumer", s.consumer)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// Same improvised semaphore as the Pub/Sub subscriber.
+	sem := make(chan struct{}, s.maxConcurrent)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.listenFanout(ctx, &wg, sem)
+	}()
+
+	// Entries delivered to this consumer before a restart are still pending, handle them first.
+	for id := "0"; ; {
+		pending, err := s.read(ctx, id)
+		if err != nil && ctx.Err() == nil {
+			s.logger.Warn("failed to read pending entries", "error", err)
+		}
+		if len(pending) == 0 {
+			break
+		}
+		s.dispatch(ctx, &wg, sem, pending)
+		id = pending[len(pending)-1].ID
+	}
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= s.config.StreamClaimMinIdle {
+			claimed, err := s.claim(ctx)
+			if err != nil && ctx.Err() == nil {
+				s.logger.Warn("failed to reclaim pending entries", "error", err)
+			}
+			s.dispatch(ctx, &wg, sem, claimed)
+			lastClaim = time.Now()
+		}
+
+		entries, err := s.read(ctx, ">")
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			s.logger.Warn("failed to read stream", "error", err)
+			// Avoid hammering Redis while it is unreachable.
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		s.dispatch(ctx, &wg, sem, entries)
+	}
+
+	s.logger.Info("shutting down Redis stream subscriber")
+	return nil
+}
+
+// read returns the entries of the stream for this consumer, starting after id.
+// The ">" id returns the entries never delivered to the group and waits for them at most streamBlock.
+func (s *StreamSubscriber) read(ctx context.Context, id string) ([]redis.XMessage, error) {
+	args := &redis.XReadGroupArgs{
+		Group:    s.group,
+		Consumer: s.consumer,
+		Streams:  []string{s.stream, id},
+		Count:    int64(s.maxConcurrent),
+	}
+	if id == ">" {
+		args.Block = streamBlock
+	}
+	streams, err := s.client.XReadGroup(ctx, args).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res []redis.XMessage
+	for _, stream := range streams {
+		res = append(res, stream.Messages...)
+	}
+	return res, nil
+}
+
+// claim takes over the entries left pending by the other consumers of the group for too long.
+func (s *StreamSubscriber) claim(ctx context.Context) ([]redis.XMessage, error) {
+	var res []redis.XMessage
+	start := "0-0"
+	for {
+		entries, next, err := s.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   s.stream,
+			Group:    s.group,
+			MinIdle:  s.config.StreamClaimMinIdle,
+			Start:    start,
+			Count:    int64(s.maxConcurrent),
+			Consumer: s.consumer,
+		}).Result()
+		if err != nil {
+			return res, err
+		}
+		res = append(res, entries...)
+		if next == "0-0" || len(entries) == 0 {
+			return res, nil
+		}
+		start = next
+	}
+}
+
+func (s *StreamSubscriber) dispatch(ctx context.Context, wg *sync.WaitGroup, sem chan struct{}, entries []redis.XMessage) {
+	for _, entry := range entries {
+		select {
+		case sem <- struct{}{}:
+			wg.Add(1)
+			go func(e redis.XMessage) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				s.handleEntry(ctx, e)
+			}(entry)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleEntry handles an entry, forwards it to the other instances and acknowledges it.
+// Entries that can't be decoded are moved to the dead-letter store, they would fail the same way on every retry.
+func (s *StreamSubscriber) handleEntry(ctx context.Context, entry redis.XMessage) {
+	payload, _ := entry.Values[streamPayloadField].(string)
+	incidentMsg, err := decodeIncidentMessage(payload)
+	if err != nil {
+		s.logger.Error("error handling stream entry", "id", entry.ID, "error", err)
+		if err := rejectMessage(ctx, s.deadLetters, s.stream, payload, err); err != nil {
+			// Leave the entry pending rather than losing it.
+			s.logger.Error("failed to store dead letter", "id", entry.ID, "error", err)
+			return
+		}
+	} else {
+		s.logger.Debug("incident stream entry received", "id", entry.ID, "incidentMsg", incidentMsg)
+		spanCtx, span := startIncidentSpan(ctx, incidentMsg, s.stream)
+		s.handler.MulticastIncident(spanCtx, &incidentMsg.Data, string(incidentMsg.Action))
+		span.End()
+
+		if err := s.forward(ctx, payload); err != nil {
+			// Leave the entry pending, it will be handled again once reclaimed.
+			s.logger.Error("failed to forward stream entry", "id", entry.ID, "error", err)
+			return
+		}
+	}
+
+	if err := s.client.XAck(ctx, s.stream, s.group, entry.ID).Err(); err != nil {
+		s.logger.Warn("failed to acknowledge stream entry", "id", entry.ID, "error", err)
+	}
+}
+
+func (s *StreamSubscriber) forward(ctx context.Context, payload string) error {
+	msg, err := json.Marshal(fanoutMessage{Origin: s.consumer, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return s.client.Publish(ctx, s.fanoutChannel(), msg).Err()
+}
+
+// listenFanout handles the incidents consumed from the stream by the other instances.
+func (s *StreamSubscriber) listenFanout(ctx context.Context, wg *sync.WaitGroup, sem chan struct{}) {
+	pubsub := s.client.Subscribe(ctx, s.fanoutChannel())
+	defer func() {
+		if err := pubsub.Close(); err != nil {
+			s.logger.Warn("failed to close fanout pubsub", "error", err)
+		}
+	}()
+
+	msgCh := pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok {
+				s.logger.Warn("fanout channel closed by Redis")
+				return
+			}
+			var fanout fanoutMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &fanout); err != nil {
+				s.logger.Error("failed to decode fanout message", "error", err)
+				continue
+			}
+			if fanout.Origin == s.consumer {
+				continue
+			}
+			incidentMsg, err := decodeIncidentMessage(fanout.Payload)
+			if err != nil {
+				s.logger.Error("error handling fanout message", "error", err)
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() { <-sem }()
+					ctx, span := startIncidentSpan(ctx, incidentMsg, s.fanoutChannel())
+					defer span.End()
+					s.handler.MulticastIncident(ctx, &incidentMsg.Data, string(incidentMsg.Action))
+				}()
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *StreamSubscriber) Publish(ctx context.Context, payload string) error {
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		Values: map[string]any{streamPayloadField: payload},
+	}).Err()
+}
+
+func (s *StreamSubscriber) fanoutChannel() string {
+	return fmt.Sprintf("%s:fanout", s.stream)
+}
@@ -2,31 +2,45 @@ package subscriber
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"github.com/redis/go-redis/v9"
 	"log/slog"
 	"supmap-navigation/internal/config"
 	"supmap-navigation/internal/incidents"
 )
 
+// IncidentSource delivers the incidents published by supmap-incidents to an IncidentHandler.
+type IncidentSource interface {
+	// Start reads the incidents until the context is done.
+	Start(ctx context.Context) error
+	// Publish sends an incident message to the source, as supmap-incidents would.
+	Publish(ctx context.Context, payload string) error
+}
+
+// IncidentHandler handles the incidents read by a source. It is implemented by incidents.Multicaster.
+type IncidentHandler interface {
+	MulticastIncident(ctx context.Context, incident *incidents.Incident, action string)
+}
+
+// Subscriber is the Redis Pub/Sub IncidentSource.
 type Subscriber struct {
 	config        *config.Config
 	logger        *slog.Logger
 	client        *redis.Client
 	topic         string
 	maxConcurrent int
-	multicaster   *incidents.Multicaster
+	handler       IncidentHandler
+	deadLetters   DeadLetterStore
 }
 
-func NewSubscriber(config *config.Config, logger *slog.Logger, client *redis.Client, topic string, maxConcurrent int, multicaster *incidents.Multicaster) *Subscriber {
+func NewSubscriber(config *config.Config, logger *slog.Logger, client *redis.Client, topic string, maxConcurrent int, handler IncidentHandler, deadLetters DeadLetterStore) *Subscriber {
 	return &Subscriber{
 		config,
 		logger,
 		client,
 		topic,
 		maxConcurrent,
-		multicaster,
+		handler,
+		deadLetters,
 	}
 }
 
@@ -72,18 +86,22 @@ func (s *Subscriber) Start(ctx context.Context) error {
 }
 
 func (s *Subscriber) handleMessage(ctx context.Context, msg *redis.Message) error {
-	var incidentMsg IncidentMessage
-
-	if err := json.Unmarshal([]byte(msg.Payload), &incidentMsg); err != nil {
-		return fmt.Errorf("failed to decode pub/sub incident message: %w", err)
-	}
-
-	if !incidentMsg.Action.IsValid() {
-		return fmt.Errorf("invalid incident action: %s", incidentMsg.Action)
+	incidentMsg, err := decodeIncidentMessage(msg.Payload)
+	if err != nil {
+		if dlErr := rejectMessage(ctx, s.deadLetters, msg.Channel, msg.Payload, err); dlErr != nil {
+			s.logger.Error("failed to store dead letter", "error", dlErr)
+		}
+		return err
 	}
 
 	s.logger.Debug("incident pub/sub message received", "incidentMsg", incidentMsg)
 
-	s.multicaster.MulticastIncident(ctx, &incidentMsg.Data, string(incidentMsg.Action))
+	ctx, span := startIncidentSpan(ctx, incidentMsg, msg.Channel)
+	defer span.End()
+	s.handler.MulticastIncident(ctx, &incidentMsg.Data, string(incidentMsg.Action))
 	return nil
 }
+
+func (s *Subscriber) Publish(ctx context.Context, payload string) error {
+	return s.client.Publish(ctx, s.topic, payload).Err()
+}
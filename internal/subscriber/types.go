@@ -1,9 +1,53 @@
 package subscriber
 
-import "supmap-navigation/internal/incidents"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"supmap-navigation/internal/incidents"
+	"supmap-navigation/internal/tracing"
+)
 
 // IncidentMessage represents any message received in the incidents pub/sub channel.
 type IncidentMessage struct {
 	Data   incidents.Incident `json:"data"`
 	Action incidents.Action   `json:"action"`
+	// TraceContext is the W3C trace context (traceparent, tracestate) of the publisher, if it propagated one.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+var tracer = tracing.Tracer("supmap-navigation/internal/subscriber")
+
+// decodeIncidentMessage decodes and validates a message received from supmap-incidents.
+func decodeIncidentMessage(payload string) (*IncidentMessage, error) {
+	var incidentMsg IncidentMessage
+
+	if err := json.Unmarshal([]byte(payload), &incidentMsg); err != nil {
+		return nil, fmt.Errorf("failed to decode incident message: %w", err)
+	}
+
+	if !incidentMsg.Action.IsValid() {
+		return nil, fmt.Errorf("invalid incident action: %s", incidentMsg.Action)
+	}
+	return &incidentMsg, nil
+}
+
+// startIncidentSpan starts the span of the handling of an incident message read from source,
+// continuing the trace of the publisher if the message carries one.
+func startIncidentSpan(ctx context.Context, incidentMsg *IncidentMessage, source string) (context.Context, trace.Span) {
+	if len(incidentMsg.TraceContext) > 0 {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(incidentMsg.TraceContext))
+	}
+	return tracer.Start(ctx, "subscriber.handleMessage",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.source", source),
+			attribute.Int64("incident.id", incidentMsg.Data.ID),
+			attribute.String("incident.action", string(incidentMsg.Action)),
+		),
+	)
 }
@@ -0,0 +1,313 @@
+package tracking
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"supmap-navigation/internal/config"
+	"supmap-navigation/internal/congestion"
+	"supmap-navigation/internal/gis"
+	"supmap-navigation/internal/metrics"
+	"supmap-navigation/internal/navigation"
+	"supmap-navigation/internal/reroute"
+	"supmap-navigation/internal/traffic"
+	"supmap-navigation/internal/ws"
+	"time"
+)
+
+// Tracker follows the progress of the clients on their route from their position updates.
+type Tracker struct {
+	config      *config.Config
+	logger      *slog.Logger
+	rerouter    *reroute.Rerouter
+	breadcrumbs navigation.BreadcrumbStore
+	traffic     traffic.Store
+	// tripIncidents counts the incidents sent during the trip, for the arrival summary.
+	tripIncidents navigation.TripIncidentStore
+	// detector detects the congestions from the observed speeds, nil if congestion detection is disabled.
+	detector *congestion.Detector
+}
+
+func NewTracker(config *config.Config, logger *slog.Logger, rerouter *reroute.Rerouter, breadcrumbs navigation.BreadcrumbStore, traffic traffic.Store, detector *congestion.Detector, tripIncidents navigation.TripIncidentStore) *Tracker {
+	return &Tracker{
+		config:        config,
+		logger:        logger,
+		rerouter:      rerouter,
+		breadcrumbs:   breadcrumbs,
+		traffic:       traffic,
+		detector:      detector,
+		tripIncidents: tripIncidents,
+	}
+}
+
+// HandlePosition implements ws.PositionHandler.
+func (t *Tracker) HandlePosition(ctx context.Context, client *ws.Client, session *navigation.Session, position navigation.Position) {
+	if session.LastPosition.Lat != 0 || session.LastPosition.Lon != 0 {
+		session.Trip.DistanceDriven += gis.Haversine(
+			gis.Point{Lat: session.LastPosition.Lat, Lon: session.LastPosition.Lon},
+			gis.Point{Lat: position.Lat, Lon: position.Lon},
+		)
+	}
+	t.observeTraffic(ctx, client, session, position)
+	session.LastPosition = position
+
+	if t.checkArrival(ctx, client, session) {
+		// Always keep the end of the trip.
+		t.recordBreadcrumb(ctx, client, session, true)
+		return
+	}
+	t.recordBreadcrumb(ctx, client, session, false)
+	t.checkWaypoints(ctx, client, session)
+	if err := t.rerouter.CommitExpiredRoutes(ctx, session, time.Now()); err != nil {
+		t.logger.Warn("failed to commit expired route options", "clientID", client.ID, "error", err)
+	}
+	if session.PendingRoutes == nil {
+		t.checkOffRoute(ctx, client, session)
+	}
+	t.updateProgress(client, session)
+	t.announceManeuver(ctx, client, session)
+}
+
+// recordBreadcrumb appends the last position to the breadcrumb trail of the session,
+// unless it is too close in distance and time to the last recorded one and force is false.
+func (t *Tracker) recordBreadcrumb(ctx context.Context, client *ws.Client, session *navigation.Session, force bool) {
+	position := session.LastPosition
+	if position.Timestamp.IsZero() {
+		position.Timestamp = time.Now()
+	}
+	if !force && !navigation.NeedsBreadcrumb(session.LastBreadcrumb, position, t.config.BreadcrumbsMinDistance, t.config.BreadcrumbsMinInterval) {
+		return
+	}
+
+	if err := t.breadcrumbs.Append(ctx, session.ID, position); err != nil {
+		t.logger.Warn("failed to record breadcrumb", "clientID", client.ID, "error", err)
+		return
+	}
+	session.LastBreadcrumb = &position
+}
+
+// observeTraffic records the speed of the client between its last position and the new one,
+// and hands it to the congestion detector.
+func (t *Tracker) observeTraffic(ctx context.Context, client *ws.Client, session *navigation.Session, position navigation.Position) {
+	observations := traffic.Observe(session.Route, session.LastPosition, position, t.config.OffRouteTolerance, t.config.TrafficMaxFixGap)
+	if len(observations) == 0 {
+		return
+	}
+	if err := t.traffic.Record(ctx, observations); err != nil {
+		t.logger.Warn("failed to record traffic observations", "clientID", client.ID, "error", err)
+	} else {
+		metrics.TrafficObservations.Add(float64(len(observations)))
+	}
+
+	if t.detector == nil {
+		return
+	}
+	if err := t.detector.Observe(ctx, session.ID, observations); err != nil {
+		t.logger.Warn("failed to detect congestion", "clientID", client.ID, "error", err)
+	}
+}
+
+// ArrivedPayload represents the payload of the "arrived" message sent to the clients.
+type ArrivedPayload struct {
+	StartedAt      time.Time `json:"started_at"`
+	ArrivedAt      time.Time `json:"arrived_at"`
+	Duration       float64   `json:"duration"`
+	DistanceDriven float64   `json:"distance_driven"`
+	Reroutes       int       `json:"reroutes"`
+	Incidents      int       `json:"incidents"`
+}
+
+// checkArrival marks the session completed and sends the trip summary to the client
+// once it is close enough to its destination, at the end of its route.
+// Skipped waypoints don't prevent the arrival.
+// It returns true if the client has arrived.
+func (t *Tracker) checkArrival(ctx context.Context, client *ws.Client, session *navigation.Session) bool {
+	destination, ok := session.Route.Destination()
+	if !ok {
+		return false
+	}
+	distance := gis.Haversine(
+		gis.Point{Lat: session.LastPosition.Lat, Lon: session.LastPosition.Lon},
+		gis.Point{Lat: destination.Lat, Lon: destination.Lon},
+	)
+	if distance > t.config.ArrivalRadius {
+		return false
+	}
+
+	now := time.Now()
+	// Being close to the destination is not enough if the route passes by it earlier.
+	// At the start of a round trip, the position may even snap onto the end of the route:
+	// the client must also have driven a good part of the route to get there.
+	progress := navigation.ComputeProgress(session.Route, session.LastPosition, now)
+	if progress != nil && (progress.RemainingDistance > t.config.ArrivalRadius || session.Trip.DistanceDriven < progress.DistanceAlong/2) {
+		return false
+	}
+
+	session.Trip.CompletedAt = &now
+	t.logger.Info("client arrived at destination", "clientID", client.ID)
+
+	incidentsCount, err := t.tripIncidents.Count(ctx, session)
+	if err != nil {
+		t.logger.Warn("failed to count trip incidents", "clientID", client.ID, "error", err)
+	}
+
+	payload, err := json.Marshal(ArrivedPayload{
+		StartedAt:      session.Trip.StartedAt,
+		ArrivedAt:      now,
+		Duration:       now.Sub(session.Trip.StartedAt).Seconds(),
+		DistanceDriven: session.Trip.DistanceDriven,
+		Reroutes:       session.Trip.Reroutes,
+		Incidents:      incidentsCount,
+	})
+	if err != nil {
+		t.logger.Warn("failed to marshal arrival", "clientID", client.ID, "error", err)
+		return true
+	}
+	client.Manager.Send(ctx, client.ID, ws.Message{
+		Type: "arrived",
+		Data: payload,
+	})
+	return true
+}
+
+// WaypointReachedPayload represents the payload of the "waypoint_reached" message sent to the clients.
+type WaypointReachedPayload struct {
+	Waypoint navigation.Location `json:"waypoint"`
+	// RemainingWaypoints is the number of intermediate locations left before the destination.
+	RemainingWaypoints int `json:"remaining_waypoints"`
+}
+
+// checkWaypoints removes from the route the intermediate locations reached by the client,
+// so that they are not part of the next route recalculations, and notifies the client.
+func (t *Tracker) checkWaypoints(ctx context.Context, client *ws.Client, session *navigation.Session) {
+	position := gis.Point{Lat: session.LastPosition.Lat, Lon: session.LastPosition.Lon}
+	for i := 0; i < len(session.Route.Waypoints()); {
+		waypoint := session.Route.Waypoints()[i]
+		if gis.Haversine(position, gis.Point{Lat: waypoint.Lat, Lon: waypoint.Lon}) > t.config.WaypointRadius {
+			i++
+			continue
+		}
+
+		// Waypoints start at index 1 of the locations, after the origin.
+		session.Route.Locations = slices.Delete(session.Route.Locations, i+1, i+2)
+		t.logger.Info("client reached a waypoint", "clientID", client.ID, "waypoint", waypoint)
+
+		payload, err := json.Marshal(WaypointReachedPayload{
+			Waypoint:           waypoint,
+			RemainingWaypoints: len(session.Route.Waypoints()),
+		})
+		if err != nil {
+			t.logger.Warn("failed to marshal waypoint", "clientID", client.ID, "error", err)
+			continue
+		}
+		client.Manager.Send(ctx, client.ID, ws.Message{
+			Type: "waypoint_reached",
+			Data: payload,
+		})
+	}
+}
+
+// checkOffRoute reroutes the client once enough consecutive positions have been received away from the route.
+// A single off-route position is not enough, GPS fixes are too noisy for that.
+func (t *Tracker) checkOffRoute(ctx context.Context, client *ws.Client, session *navigation.Session) {
+	position := gis.Point{Lat: session.LastPosition.Lat, Lon: session.LastPosition.Lon}
+	distance := gis.DistanceToPolyline(position, session.Route.GISPolyline())
+	if distance <= t.config.OffRouteTolerance {
+		session.OffRouteCount = 0
+		return
+	}
+
+	session.OffRouteCount++
+	t.logger.Debug("position is off-route", "clientID", client.ID, "distance", distance, "count", session.OffRouteCount)
+	if session.OffRouteCount < t.config.OffRouteConsecutiveFixes {
+		return
+	}
+
+	t.logger.Info("client is off-route, rerouting", "clientID", client.ID)
+	if err := t.rerouter.Reroute(ctx, session, reroute.InfoOffRoute); err != nil {
+		t.logger.Warn("failed to reroute off-route client", "clientID", client.ID, "error", err)
+		// Wait for another series of off-route positions before trying again.
+		session.OffRouteCount = 0
+	}
+}
+
+// updateProgress computes the client progress on its route,
+// and sends it to the client if the last "progress" message is old enough.
+func (t *Tracker) updateProgress(client *ws.Client, session *navigation.Session) {
+	now := time.Now()
+	session.Progress = navigation.ComputeProgress(session.Route, session.LastPosition, now)
+	if session.Progress == nil {
+		return
+	}
+	if now.Sub(session.ProgressSentAt) < t.config.ProgressInterval {
+		return
+	}
+
+	payload, err := json.Marshal(session.Progress)
+	if err != nil {
+		t.logger.Warn("failed to marshal progress", "clientID", client.ID, "error", err)
+		return
+	}
+	// Progress is only relevant live, it is not kept in the outbox.
+	client.Send(ws.Message{
+		Type: "progress",
+		Data: payload,
+	})
+	session.ProgressSentAt = now
+}
+
+// ManeuverPayload represents the payload of the "maneuver" messages sent to the clients.
+type ManeuverPayload struct {
+	ManeuverIndex int      `json:"maneuver_index"`
+	Type          uint8    `json:"type"`
+	Instruction   string   `json:"instruction"`
+	StreetNames   []string `json:"street_names"`
+	Distance      float64  `json:"distance"`
+}
+
+// announceManeuver sends the next maneuver to the client when it crosses one of the announce distances.
+// Each distance is announced at most once per maneuver.
+func (t *Tracker) announceManeuver(ctx context.Context, client *ws.Client, session *navigation.Session) {
+	if session.Progress == nil {
+		return
+	}
+	index, distance, ok := session.Route.NextManeuver(session.Progress.DistanceAlong)
+	if !ok {
+		return
+	}
+
+	// Find the smallest announce distance already crossed.
+	threshold := 0.0
+	for _, d := range t.config.ManeuverAnnounceDistances {
+		if distance <= d {
+			threshold = d
+		}
+	}
+	if threshold == 0 {
+		return
+	}
+
+	last := session.LastAnnouncement
+	if last != nil && last.ManeuverIndex == index && last.Threshold <= threshold {
+		return
+	}
+
+	maneuver := session.Route.Maneuvers[index]
+	payload, err := json.Marshal(ManeuverPayload{
+		ManeuverIndex: index,
+		Type:          maneuver.Type,
+		Instruction:   maneuver.Instruction,
+		StreetNames:   maneuver.StreetNames,
+		Distance:      distance,
+	})
+	if err != nil {
+		t.logger.Warn("failed to marshal maneuver", "clientID", client.ID, "error", err)
+		return
+	}
+	client.Manager.Send(ctx, client.ID, ws.Message{
+		Type: "maneuver",
+		Data: payload,
+	})
+	session.LastAnnouncement = &navigation.Announcement{ManeuverIndex: index, Threshold: threshold}
+}
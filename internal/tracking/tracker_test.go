@@ -0,0 +1,90 @@
+package tracking
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"supmap-navigation/internal/config"
+	"supmap-navigation/internal/gis"
+	"supmap-navigation/internal/navigation"
+	"supmap-navigation/internal/ws"
+	"testing"
+	"time"
+)
+
+type discardOutbox struct{}
+
+func (discardOutbox) Append(_ context.Context, _ string, msg ws.Message) (ws.Message, error) {
+	return msg, nil
+}
+
+func (discardOutbox) Since(context.Context, string, uint64) ([]ws.Message, error) {
+	return nil, nil
+}
+
+type localCluster struct{}
+
+func (localCluster) Register(context.Context, string) error   { return nil }
+func (localCluster) Unregister(context.Context, string) error { return nil }
+func (localCluster) Relay(context.Context, string, ws.Message) (bool, error) {
+	return false, nil
+}
+
+type noTripIncidents struct{}
+
+func (noTripIncidents) Add(context.Context, *navigation.Session, int64) error { return nil }
+func (noTripIncidents) Count(context.Context, *navigation.Session) (int, error) {
+	return 0, nil
+}
+
+func TestCheckArrival(t *testing.T) {
+	// A round trip from the depot to a stop about 730 m east, through a waypoint, and back.
+	depot := navigation.Location{Lat: 49.18, Lon: -0.37}
+	waypoint := navigation.Location{Lat: 49.18, Lon: -0.365}
+	stop := navigation.Location{Lat: 49.18, Lon: -0.36}
+	route := navigation.Route{
+		Polyline: []navigation.Point{
+			{Lat: depot.Lat, Lon: depot.Lon},
+			{Lat: waypoint.Lat, Lon: waypoint.Lon},
+			{Lat: stop.Lat, Lon: stop.Lon},
+			{Lat: 49.1801, Lon: waypoint.Lon},
+			{Lat: 49.1801, Lon: depot.Lon},
+			{Lat: depot.Lat, Lon: depot.Lon},
+		},
+		Locations: []navigation.Location{depot, waypoint, stop, depot},
+	}
+	routeLength := gis.CumulativeDistances(route.GISPolyline())[len(route.Polyline)-1]
+
+	tests := []struct {
+		name     string
+		position navigation.Position
+		driven   float64
+		want     bool
+	}{
+		{name: "start of the round trip", position: navigation.Position{Lat: 49.18005, Lon: -0.37}, driven: 0, want: false},
+		{name: "passing by the destination", position: navigation.Position{Lat: 49.18, Lon: -0.3699}, driven: 10, want: false},
+		{name: "far from the destination", position: navigation.Position{Lat: 49.18, Lon: -0.36}, driven: routeLength / 2, want: false},
+		{name: "back at the depot with a skipped waypoint", position: navigation.Position{Lat: 49.18005, Lon: -0.37}, driven: routeLength, want: true},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := ws.NewManager(context.Background(), logger, nil, discardOutbox{}, gis.NewGridIndex(0.01), localCluster{})
+	tracker := NewTracker(&config.Config{ArrivalRadius: 30}, logger, nil, nil, nil, nil, noTripIncidents{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &navigation.Session{
+				ID:           "session",
+				Route:        route,
+				LastPosition: tt.position,
+				Trip:         navigation.Trip{StartedAt: time.Now(), DistanceDriven: tt.driven},
+			}
+			client := ws.NewClient(session.ID, "", nil, manager)
+			if got := tracker.checkArrival(context.Background(), client, session); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if session.IsCompleted() != tt.want {
+				t.Errorf("got completed %v, want %v", session.IsCompleted(), tt.want)
+			}
+		})
+	}
+}
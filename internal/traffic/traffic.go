@@ -0,0 +1,247 @@
+package traffic
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"supmap-navigation/internal/gis"
+	"supmap-navigation/internal/navigation"
+	"time"
+)
+
+// maxSpeed is the speed (in km/h) above which an observation is considered a GPS glitch.
+const maxSpeed = 250
+
+// coordinatesPrecision is the number of decimals of the coordinates in the segment IDs (about one metre).
+const coordinatesPrecision = 5
+
+// Segment is a directed segment of a road, between two consecutive points of the route polylines.
+// The polylines computed by supmap-gis for the same road share their points,
+// so the sessions driving the same road observe the same segments.
+type Segment struct {
+	ID   string    `json:"id"`
+	From gis.Point `json:"from"`
+	To   gis.Point `json:"to"`
+}
+
+func NewSegment(from, to gis.Point) Segment {
+	return Segment{
+		ID:   formatCoordinates(from) + ":" + formatCoordinates(to),
+		From: from,
+		To:   to,
+	}
+}
+
+// ParseSegment builds the segment back from its ID.
+func ParseSegment(id string) (Segment, error) {
+	from, to, ok := strings.Cut(id, ":")
+	if !ok {
+		return Segment{}, fmt.Errorf("invalid segment ID %q", id)
+	}
+	a, err := parseCoordinates(from)
+	if err != nil {
+		return Segment{}, fmt.Errorf("invalid segment ID %q: %w", id, err)
+	}
+	b, err := parseCoordinates(to)
+	if err != nil {
+		return Segment{}, fmt.Errorf("invalid segment ID %q: %w", id, err)
+	}
+	return Segment{ID: id, From: a, To: b}, nil
+}
+
+// Midpoint is the point used to find the segment in an area.
+func (s Segment) Midpoint() gis.Point {
+	return gis.Interpolate(s.From, s.To, 0.5)
+}
+
+// Observation is the speed of a session on a segment, measured between two of its positions.
+type Observation struct {
+	Segment Segment
+	// Speed is the observed speed in km/h.
+	Speed float64
+	// ExpectedSpeed is the speed (in km/h) the route was computed with on this segment, zero if unknown.
+	ExpectedSpeed float64
+	At            time.Time
+}
+
+// Observe computes the speed of a session between two consecutive positions, both snapped onto its route polyline.
+// The speed is the distance travelled along the polyline divided by the time elapsed,
+// it is observed on every segment of the polyline between the two positions.
+// No observation is made if a position is further than tolerance metres from the route,
+// if the positions are more than maxGap apart or unordered, or if the speed is not plausible.
+func Observe(route navigation.Route, from, to navigation.Position, tolerance float64, maxGap time.Duration) []Observation {
+	elapsed := to.Timestamp.Sub(from.Timestamp)
+	if from.Timestamp.IsZero() || elapsed <= 0 || elapsed > maxGap {
+		return nil
+	}
+
+	polyline := route.GISPolyline()
+	if len(polyline) < 2 {
+		return nil
+	}
+	start, _ := gis.ProjectOnPolyline(gis.Point{Lat: from.Lat, Lon: from.Lon}, polyline)
+	end, _ := gis.ProjectOnPolyline(gis.Point{Lat: to.Lat, Lon: to.Lon}, polyline)
+	if start.Distance > tolerance || end.Distance > tolerance {
+		return nil
+	}
+
+	if end.SegmentIndex < start.SegmentIndex {
+		// Moved back across a vertex, either going backwards or GPS noise of a stopped client on a dense polyline.
+		return nil
+	}
+
+	cumulative := gis.CumulativeDistances(polyline)
+	travelled := end.DistanceAlong(cumulative) - start.DistanceAlong(cumulative)
+	if travelled < -tolerance {
+		// Going backwards, the client is probably not following its route.
+		return nil
+	}
+	// A stopped client may seem to move back a little because of the GPS noise.
+	travelled = math.Max(travelled, 0)
+
+	speed := travelled / elapsed.Seconds() * 3.6
+	if speed > maxSpeed {
+		return nil
+	}
+
+	res := make([]Observation, 0, end.SegmentIndex-start.SegmentIndex+1)
+	for i := start.SegmentIndex; i <= end.SegmentIndex; i++ {
+		res = append(res, Observation{
+			Segment:       NewSegment(polyline[i], polyline[i+1]),
+			Speed:         speed,
+			ExpectedSpeed: expectedSpeed(route, i),
+			At:            to.Timestamp,
+		})
+	}
+	return res
+}
+
+// expectedSpeed returns the speed (in km/h) of the maneuver covering the given segment of the route, zero if unknown.
+func expectedSpeed(route navigation.Route, segmentIndex int) float64 {
+	for _, m := range route.Maneuvers {
+		if int(m.BeginShapeIndex) <= segmentIndex && segmentIndex < int(m.EndShapeIndex) && m.Time > 0 {
+			return m.Length / m.Time * 3600
+		}
+	}
+	return 0
+}
+
+// Store aggregates the observations into time buckets per segment.
+type Store interface {
+	Record(ctx context.Context, observations []Observation) error
+	// Query returns the statistics of the segments whose midpoint is within radius metres of center,
+	// for every bucket overlapping [from, to].
+	Query(ctx context.Context, center gis.Point, radius float64, from, to time.Time) ([]SegmentStats, error)
+}
+
+// SegmentStats are the statistics of a segment over a time bucket, or several once merged.
+type SegmentStats struct {
+	Segment Segment   `json:"segment"`
+	Bucket  time.Time `json:"bucket"`
+	Samples int64     `json:"samples"`
+	// AverageSpeed is the average observed speed, in km/h.
+	AverageSpeed float64 `json:"average_speed"`
+	// ExpectedSpeed is the average speed (in km/h) the routes were computed with, zero if unknown.
+	ExpectedSpeed float64 `json:"expected_speed"`
+	// Congestion is the relative slowdown compared to the expected speed, between 0 (free flow) and 1 (stopped).
+	// It is nil if the expected speed is unknown.
+	Congestion *float64 `json:"congestion,omitempty"`
+
+	// expectedSamples is the number of samples ExpectedSpeed is averaged on.
+	expectedSamples int64
+}
+
+// NewSegmentStats builds the statistics of a bucket from the sums of the observations.
+func NewSegmentStats(segment Segment, bucket time.Time, samples int64, speedSum float64, expectedSamples int64, expectedSum float64) SegmentStats {
+	stats := SegmentStats{Segment: segment, Bucket: bucket, Samples: samples, expectedSamples: expectedSamples}
+	if samples > 0 {
+		stats.AverageSpeed = speedSum / float64(samples)
+	}
+	if expectedSamples > 0 {
+		stats.ExpectedSpeed = expectedSum / float64(expectedSamples)
+	}
+	stats.computeCongestion()
+	return stats
+}
+
+func (s *SegmentStats) computeCongestion() {
+	s.Congestion = nil
+	if s.ExpectedSpeed <= 0 {
+		return
+	}
+	congestion := math.Max(0, math.Min(1, 1-s.AverageSpeed/s.ExpectedSpeed))
+	s.Congestion = &congestion
+}
+
+// Merge merges the buckets of each segment, keeping the most recent bucket as Bucket.
+// Segments with less than minSamples samples are left out, so that a single session can't be followed.
+// The result is sorted by decreasing congestion, segments with an unknown congestion last.
+func Merge(stats []SegmentStats, minSamples int64) []SegmentStats {
+	merged := make(map[string]*SegmentStats)
+	var order []string
+	for _, s := range stats {
+		m, ok := merged[s.Segment.ID]
+		if !ok {
+			copied := s
+			merged[s.Segment.ID] = &copied
+			order = append(order, s.Segment.ID)
+			continue
+		}
+		samples := m.Samples + s.Samples
+		if samples > 0 {
+			m.AverageSpeed = (m.AverageSpeed*float64(m.Samples) + s.AverageSpeed*float64(s.Samples)) / float64(samples)
+		}
+		expectedSamples := m.expectedSamples + s.expectedSamples
+		if expectedSamples > 0 {
+			m.ExpectedSpeed = (m.ExpectedSpeed*float64(m.expectedSamples) + s.ExpectedSpeed*float64(s.expectedSamples)) / float64(expectedSamples)
+		}
+		m.Samples, m.expectedSamples = samples, expectedSamples
+		if s.Bucket.After(m.Bucket) {
+			m.Bucket = s.Bucket
+		}
+		m.computeCongestion()
+	}
+
+	res := make([]SegmentStats, 0, len(order))
+	for _, id := range order {
+		if merged[id].Samples >= minSamples {
+			res = append(res, *merged[id])
+		}
+	}
+	slices.SortStableFunc(res, func(a, b SegmentStats) int {
+		switch {
+		case a.Congestion == nil && b.Congestion == nil:
+			return 0
+		case a.Congestion == nil:
+			return 1
+		case b.Congestion == nil:
+			return -1
+		}
+		return cmp.Compare(*b.Congestion, *a.Congestion)
+	})
+	return res
+}
+
+func formatCoordinates(p gis.Point) string {
+	return strconv.FormatFloat(p.Lat, 'f', coordinatesPrecision, 64) + "," + strconv.FormatFloat(p.Lon, 'f', coordinatesPrecision, 64)
+}
+
+func parseCoordinates(s string) (gis.Point, error) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if !ok {
+		return gis.Point{}, fmt.Errorf("invalid coordinates %q", s)
+	}
+	var p gis.Point
+	var err error
+	if p.Lat, err = strconv.ParseFloat(lat, 64); err != nil {
+		return gis.Point{}, err
+	}
+	if p.Lon, err = strconv.ParseFloat(lon, 64); err != nil {
+		return gis.Point{}, err
+	}
+	return p, nil
+}
@@ -0,0 +1,90 @@
+package traffic
+
+import (
+	"supmap-navigation/internal/navigation"
+	"testing"
+	"time"
+)
+
+// straightRoute returns a route going east with a point every 0.0001° of longitude (about 7 m at this latitude).
+func straightRoute(points int) navigation.Route {
+	polyline := make([]navigation.Point, points)
+	for i := range polyline {
+		polyline[i] = navigation.Point{Lat: 49.18, Lon: -0.37 + float64(i)*0.0001}
+	}
+	return navigation.Route{Polyline: polyline}
+}
+
+func TestObserve(t *testing.T) {
+	route := straightRoute(100)
+	now := time.Now()
+	at := func(lon float64, offset time.Duration) navigation.Position {
+		return navigation.Position{Lat: 49.18, Lon: lon, Timestamp: now.Add(offset)}
+	}
+
+	tests := []struct {
+		name         string
+		from, to     navigation.Position
+		observations int
+	}{
+		{
+			name:         "forward across segments",
+			from:         at(-0.36995, 0),
+			to:           at(-0.36965, 5*time.Second),
+			observations: 4,
+		},
+		{
+			name:         "same segment",
+			from:         at(-0.36995, 0),
+			to:           at(-0.36992, 5*time.Second),
+			observations: 1,
+		},
+		{
+			// GPS noise of a stopped client: 30 m backwards across several vertices, within the tolerance.
+			name:         "backwards across vertices",
+			from:         at(-0.3696, 0),
+			to:           at(-0.3700, 5*time.Second),
+			observations: 0,
+		},
+		{
+			name:         "too far apart in time",
+			from:         at(-0.36995, 0),
+			to:           at(-0.36965, time.Minute),
+			observations: 0,
+		},
+		{
+			name:         "off route",
+			from:         at(-0.36995, 0),
+			to:           navigation.Position{Lat: 49.19, Lon: -0.36965, Timestamp: now.Add(5 * time.Second)},
+			observations: 0,
+		},
+		{
+			name:         "implausible speed",
+			from:         at(-0.3699, 0),
+			to:           at(-0.3610, 5*time.Second),
+			observations: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observations := Observe(route, tt.from, tt.to, 50, 30*time.Second)
+			if len(observations) != tt.observations {
+				t.Fatalf("got %d observations, want %d", len(observations), tt.observations)
+			}
+		})
+	}
+}
+
+func TestParseSegment(t *testing.T) {
+	route := straightRoute(2).GISPolyline()
+	segment := NewSegment(route[0], route[1])
+
+	parsed, err := ParseSegment(segment.ID)
+	if err != nil {
+		t.Fatalf("failed to parse segment %q: %v", segment.ID, err)
+	}
+	if parsed != segment {
+		t.Errorf("got %+v, want %+v", parsed, segment)
+	}
+}
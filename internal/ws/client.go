@@ -5,38 +5,57 @@ import (
 	"encoding/json"
 	"github.com/coder/websocket"
 	"github.com/coder/websocket/wsjson"
+	"supmap-navigation/internal/metrics"
 	"supmap-navigation/internal/navigation"
 	"time"
 )
 
 const (
 	// sendChannelSize controls the max number
-	// of messages that can be queued for a client.
+	// of live messages that can be queued for a client.
 	sendChannelSize = 16
-	pingPeriod      = (60 * 9 * time.Second) / 10
+	// MaxReplaySize is the maximum number of messages replayed on resume.
+	// The send buffer has room for a whole replay on top of the live messages.
+	MaxReplaySize = 256
+	// sessionTasksSize is the number of session tasks that can be queued for a client.
+	sessionTasksSize = 8
+	pingPeriod       = (60 * 9 * time.Second) / 10
 )
 
 type Message struct {
 	Type string          `json:"type"`
 	Data json.RawMessage `json:"data"`
+	// Seq is the sequence number of the message in the session outbox.
+	// It is only set on the messages sent through Manager.Send.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// ResumeData represents the data of the "resume" messages sent by the clients after a reconnection.
+type ResumeData struct {
+	LastSeq uint64 `json:"last_seq"`
 }
 
 type Client struct {
-	ID      string
+	ID string
+	// UserID is the authenticated user of the connection, empty if authentication is disabled.
+	UserID  string
 	Conn    *websocket.Conn
 	Manager *Manager
 	send    chan Message
+	tasks   chan SessionTask
 	ctx     context.Context
 	cancel  context.CancelFunc
 }
 
-func NewClient(id string, conn *websocket.Conn, manager *Manager) *Client {
+func NewClient(id string, userID string, conn *websocket.Conn, manager *Manager) *Client {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
 		ID:      id,
+		UserID:  userID,
 		Conn:    conn,
 		Manager: manager,
-		send:    make(chan Message, sendChannelSize),
+		send:    make(chan Message, sendChannelSize+MaxReplaySize),
+		tasks:   make(chan SessionTask, sessionTasksSize),
 		ctx:     ctx,
 		cancel:  cancel,
 	}
@@ -45,7 +64,10 @@ func NewClient(id string, conn *websocket.Conn, manager *Manager) *Client {
 func (c *Client) Start() {
 	go c.readPump()
 	go c.writePump()
-	c.Manager.register <- c
+	select {
+	case c.Manager.register <- c:
+	case <-c.Manager.ctx.Done():
+	}
 }
 
 func (c *Client) Close() {
@@ -59,23 +81,77 @@ func (c *Client) Send(msg Message) {
 	select {
 	case c.send <- msg:
 	default:
+		metrics.SendBufferOverflows.Inc()
 		c.Manager.forceDisconnect(c)
 	}
 }
 
+// replay queues a missed message, waiting for room in the send buffer instead of disconnecting the client:
+// a resuming client is expected to have a lot of messages to catch up on.
+// It returns false if the client is closed.
+func (c *Client) replay(msg Message) bool {
+	select {
+	case c.send <- msg:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
 func (c *Client) readPump() {
 	defer func() {
-		c.Manager.unregister <- c
+		// The manager is not reading anymore once it is shut down.
+		select {
+		case c.Manager.unregister <- c:
+		case <-c.Manager.ctx.Done():
+		}
 		c.Close()
 	}()
 
+	// The messages and the session tasks are handled by this single loop,
+	// so that the session is never updated by two goroutines at once.
+	messages := make(chan Message)
+	go c.read(messages)
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			c.handleMessage(msg)
+		case task := <-c.tasks:
+			c.runTask(task)
+		}
+	}
+}
+
+// read reads the messages of the client until the connection is closed.
+func (c *Client) read(messages chan<- Message) {
+	defer close(messages)
 	for {
 		var msg Message
 		if err := wsjson.Read(c.ctx, c.Conn, &msg); err != nil {
 			c.Manager.logger.Warn("failed to read message", "clientID", c.ID, "error", err)
-			break
+			return
 		}
-		c.handleMessage(msg)
+		select {
+		case messages <- msg:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// runTask runs a task on the cached session and saves it.
+func (c *Client) runTask(task SessionTask) {
+	session, err := c.Manager.sessionCache.GetSession(c.ctx, c.ID)
+	if err != nil {
+		c.Manager.logger.Warn("failed to get session for task", "clientID", c.ID, "error", err)
+		return
+	}
+	task(c.ctx, session)
+	if err := c.Manager.sessionCache.SetSession(c.ctx, session); err != nil {
+		c.Manager.logger.Warn("failed to save session after task", "clientID", c.ID, "error", err)
 	}
 }
 
@@ -96,6 +172,7 @@ func (c *Client) writePump() {
 				c.Manager.logger.Warn("failed to write message", "clientID", c.ID, "error", err)
 				return
 			}
+			metrics.MessagesSent.WithLabelValues(msg.Type).Inc()
 			c.Manager.logger.Debug("message sent", "clientID", c.ID, "type", msg.Type)
 		case <-ticker.C:
 			if err := c.Conn.Ping(c.ctx); err != nil {
@@ -109,6 +186,7 @@ func (c *Client) writePump() {
 }
 
 func (c *Client) handleMessage(msg Message) {
+	metrics.MessagesReceived.WithLabelValues(c.Manager.messageTypeLabel(msg.Type)).Inc()
 	switch msg.Type {
 	case "init":
 		c.Manager.logger.Debug("received init message", "clientID", c.ID, "data", msg.Data)
@@ -123,10 +201,26 @@ func (c *Client) handleMessage(msg Message) {
 			c.Manager.logger.Warn("Session ID mismatch", "clientID", c.ID, "session", session.ID)
 			return
 		}
+		if err := session.ValidateRouting(); err != nil {
+			c.Manager.logger.Warn("invalid routing preferences", "clientID", c.ID, "error", err)
+			return
+		}
+		// The session may have been created by another connection since this one was authorized.
+		if err := c.Manager.Authorize(c.ctx, c.ID, c.UserID); err != nil {
+			c.Manager.logger.Warn("session init refused", "clientID", c.ID, "error", err)
+			return
+		}
+		session.UserID = c.UserID
+		session.Trip = navigation.Trip{StartedAt: time.Now()}
+		session.PendingRoutes = nil
+		session.LastBreadcrumb = nil
 
+		c.Manager.RouteChanged(c.ctx, &session)
 		if err := c.Manager.sessionCache.SetSession(c.ctx, &session); err != nil {
 			c.Manager.logger.Warn("failed to cache session", "clientID", c.ID, "error", err)
 		}
+		// Indexed once cached, so that the sweep of the expired routes doesn't remove it in between.
+		c.Manager.IndexRoute(&session)
 	case "position":
 		c.Manager.logger.Debug("received position message", "clientID", c.ID, "data", msg.Data)
 
@@ -141,14 +235,56 @@ func (c *Client) handleMessage(msg Message) {
 			c.Manager.logger.Warn("failed to get session for position update", "clientID", c.ID, "error", err)
 			return
 		}
+		// The route was removed from the index of the instance the client was connected to before.
+		c.Manager.EnsureRouteIndexed(session)
 
-		session.LastPosition = pos
+		c.Manager.positions.HandlePosition(c.ctx, c, session, pos)
 		session.UpdatedAt = time.Now()
 
+		if session.IsCompleted() {
+			if err := c.Manager.sessionCache.DeleteSession(c.ctx, c.ID); err != nil {
+				c.Manager.logger.Warn("failed to delete completed session", "clientID", c.ID, "error", err)
+			}
+			c.Manager.UnindexRoute(c.ID)
+			return
+		}
+
 		if err := c.Manager.sessionCache.SetSession(c.ctx, session); err != nil {
 			c.Manager.logger.Warn("failed to update session with new position", "clientID", c.ID, "error", err)
 		}
+	case "resume":
+		c.Manager.logger.Debug("received resume message", "clientID", c.ID, "data", msg.Data)
+
+		var resume ResumeData
+		if err := json.Unmarshal(msg.Data, &resume); err != nil {
+			c.Manager.logger.Warn("failed to unmarshal resume message", "clientID", c.ID, "error", err)
+			return
+		}
+
+		session, err := c.Manager.sessionCache.GetSession(c.ctx, c.ID)
+		if err != nil {
+			c.Manager.logger.Warn("failed to get session to resume", "clientID", c.ID, "error", err)
+			return
+		}
+		// The route may not be indexed yet if the session was started on another run of the service.
+		c.Manager.IndexRoute(session)
+
+		missed, err := c.Manager.outbox.Since(c.ctx, c.ID, resume.LastSeq)
+		if err != nil {
+			c.Manager.logger.Warn("failed to get missed messages", "clientID", c.ID, "error", err)
+			return
+		}
+		for _, m := range missed {
+			if !c.replay(m) {
+				return
+			}
+		}
 	default:
+		if handler, ok := c.Manager.handlers[msg.Type]; ok {
+			c.Manager.logger.Debug("received message", "clientID", c.ID, "type", msg.Type, "data", msg.Data)
+			handler(c.ctx, c, msg)
+			return
+		}
 		c.Manager.logger.Debug("received unknown type message", "clientID", c.ID, "type", msg.Type)
 	}
 }
@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"supmap-navigation/internal/gis"
+	"supmap-navigation/internal/navigation"
+	"testing"
+	"time"
+)
+
+type memorySessionCache struct {
+	sessions map[string]*navigation.Session
+}
+
+func (c *memorySessionCache) SetSession(_ context.Context, session *navigation.Session) error {
+	c.sessions[session.ID] = session
+	return nil
+}
+
+func (c *memorySessionCache) GetSession(_ context.Context, sessionID string) (*navigation.Session, error) {
+	session, ok := c.sessions[sessionID]
+	if !ok {
+		return nil, navigation.ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (c *memorySessionCache) DeleteSession(_ context.Context, sessionID string) error {
+	delete(c.sessions, sessionID)
+	return nil
+}
+
+type memoryOutbox struct {
+	messages []Message
+}
+
+func (o *memoryOutbox) Append(_ context.Context, _ string, msg Message) (Message, error) {
+	msg.Seq = uint64(len(o.messages) + 1)
+	o.messages = append(o.messages, msg)
+	return msg, nil
+}
+
+func (o *memoryOutbox) Since(_ context.Context, _ string, seq uint64) ([]Message, error) {
+	return o.messages[seq:], nil
+}
+
+type localCluster struct{}
+
+func (localCluster) Register(context.Context, string) error   { return nil }
+func (localCluster) Unregister(context.Context, string) error { return nil }
+func (localCluster) Relay(context.Context, string, Message) (bool, error) {
+	return false, nil
+}
+
+func TestResumeReplaysMoreThanTheLiveBuffer(t *testing.T) {
+	for _, missed := range []int{sendChannelSize + 4, MaxReplaySize, 2 * MaxReplaySize} {
+		sessions := &memorySessionCache{sessions: map[string]*navigation.Session{"session": {ID: "session"}}}
+		outbox := &memoryOutbox{}
+		for i := 0; i < missed+3; i++ {
+			_, _ = outbox.Append(context.Background(), "session", Message{Type: "incident"})
+		}
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		manager := NewManager(context.Background(), logger, sessions, outbox, gis.NewGridIndex(0.01), localCluster{})
+		client := NewClient("session", "", nil, manager)
+
+		// A slow write pump.
+		received := make(chan Message, missed)
+		go func() {
+			for i := 0; i < missed; i++ {
+				msg := <-client.send
+				time.Sleep(10 * time.Microsecond)
+				received <- msg
+			}
+		}()
+
+		data, _ := json.Marshal(ResumeData{LastSeq: 3})
+		client.handleMessage(Message{Type: "resume", Data: data})
+
+		for i := 0; i < missed; i++ {
+			select {
+			case msg := <-received:
+				if msg.Seq != uint64(i+4) {
+					t.Fatalf("replaying %d messages: got seq %d at position %d, want %d", missed, msg.Seq, i, i+4)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("replaying %d messages: only got %d", missed, i)
+			}
+		}
+		if client.ctx.Err() != nil {
+			t.Errorf("replaying %d messages: the client was disconnected", missed)
+		}
+	}
+}
+
+func TestUnindexExpiredRoutes(t *testing.T) {
+	sessions := &memorySessionCache{sessions: map[string]*navigation.Session{"active": {ID: "active"}}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := NewManager(context.Background(), logger, sessions, &memoryOutbox{}, gis.NewGridIndex(0.01), localCluster{})
+
+	polyline := []gis.Point{{Lat: 49.18, Lon: -0.37}, {Lat: 49.18, Lon: -0.36}}
+	manager.routes.Insert("active", polyline)
+	manager.routes.Insert("expired", polyline)
+
+	if removed := manager.unindexExpiredRoutes(); removed != 1 {
+		t.Errorf("got %d removed routes, want 1", removed)
+	}
+	sessionIDs := manager.SessionsNear(polyline[0], 30)
+	if len(sessionIDs) != 1 || sessionIDs[0] != "active" {
+		t.Errorf("got indexed sessions %v, want [active]", sessionIDs)
+	}
+}
+
+type noopPositionHandler struct{}
+
+func (noopPositionHandler) HandlePosition(context.Context, *Client, *navigation.Session, navigation.Position) {
+}
+
+func TestPositionIndexesReconnectedSession(t *testing.T) {
+	polyline := []navigation.Point{{Lat: 49.18, Lon: -0.37}, {Lat: 49.18, Lon: -0.36}}
+	session := &navigation.Session{ID: "session", Route: navigation.Route{Polyline: polyline}}
+	sessions := &memorySessionCache{sessions: map[string]*navigation.Session{"session": session}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := NewManager(context.Background(), logger, sessions, &memoryOutbox{}, gis.NewGridIndex(0.01), localCluster{})
+	manager.HandlePositions(noopPositionHandler{})
+	client := NewClient("session", "", nil, manager)
+
+	// The client was connected to another instance, it goes on sending its positions without "init" nor "resume".
+	data, _ := json.Marshal(navigation.Position{Lat: 49.18, Lon: -0.365, Timestamp: time.Now()})
+	client.handleMessage(Message{Type: "position", Data: data})
+
+	if sessionIDs := manager.SessionsNear(gis.Point{Lat: 49.18, Lon: -0.365}, 30); len(sessionIDs) != 1 {
+		t.Errorf("got indexed sessions %v, want [session]", sessionIDs)
+	}
+}
+
+func TestDispatchRunsTaskInTheClientLoop(t *testing.T) {
+	sessions := &memorySessionCache{sessions: map[string]*navigation.Session{"session": {ID: "session"}}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := NewManager(context.Background(), logger, sessions, &memoryOutbox{}, gis.NewGridIndex(0.01), localCluster{})
+	client := NewClient("session", "", nil, manager)
+	manager.clients["session"] = client
+
+	if manager.Dispatch("other", func(context.Context, *navigation.Session) {}) {
+		t.Error("the task of a session not connected to this instance must not be dispatched")
+	}
+	if !manager.Dispatch("session", func(_ context.Context, session *navigation.Session) {
+		session.Trip.Reroutes++
+	}) {
+		t.Fatal("the task of a connected session must be dispatched")
+	}
+
+	// The read loop runs the queued task.
+	client.runTask(<-client.tasks)
+	if session, _ := sessions.GetSession(context.Background(), "session"); session.Trip.Reroutes != 1 {
+		t.Errorf("got %d reroutes, want 1", session.Trip.Reroutes)
+	}
+}
@@ -2,62 +2,153 @@ package ws
 
 import (
 	"context"
+	"errors"
 	"github.com/coder/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"log/slog"
+	"supmap-navigation/internal/gis"
+	"supmap-navigation/internal/metrics"
 	"supmap-navigation/internal/navigation"
+	"supmap-navigation/internal/tracing"
 	"sync"
+	"time"
 )
 
+var tracer = tracing.Tracer("supmap-navigation/internal/ws")
+
+// PositionHandler reacts to the position updates of a client.
+// The session still holds the previous position and is saved after the call,
+// or deleted if the handler marked it completed.
+type PositionHandler interface {
+	HandlePosition(ctx context.Context, client *Client, session *navigation.Session, position navigation.Position)
+}
+
+// RouteHandler reacts to the new routes of the sessions, at init and after every recalculation.
+// The session is saved after the call.
+type RouteHandler interface {
+	HandleRoute(ctx context.Context, session *navigation.Session)
+}
+
+// Outbox keeps the last messages sent to each session, so that they can be replayed to a reconnecting client.
+type Outbox interface {
+	// Append numbers the message with the next sequence number of the session and stores it.
+	Append(ctx context.Context, sessionID string, msg Message) (Message, error)
+	// Since returns the stored messages of the session whose sequence number is greater than seq.
+	Since(ctx context.Context, sessionID string, seq uint64) ([]Message, error)
+}
+
+// Cluster shares the sessions between the instances of the service,
+// so that a message can be sent to a session whatever the instance its client is connected to.
+type Cluster interface {
+	// Register marks the session as connected to this instance.
+	Register(ctx context.Context, sessionID string) error
+	// Unregister marks the session as no longer connected to this instance.
+	Unregister(ctx context.Context, sessionID string) error
+	// Relay sends the message to the instance the session is connected to.
+	// It returns false if the session isn't connected to another instance.
+	Relay(ctx context.Context, sessionID string, msg Message) (bool, error)
+}
+
+// routesSweepInterval is the interval at which the routes of the expired sessions are removed from the index.
+const routesSweepInterval = time.Minute
+
+// presenceQueueSize is the number of presence changes waiting to be written to the cluster.
+const presenceQueueSize = 256
+
+// presenceChange is a connection or disconnection of a session, to write to the cluster.
+type presenceChange struct {
+	sessionID string
+	connected bool
+}
+
+// SessionTask updates a session, see Manager.Dispatch.
+type SessionTask func(ctx context.Context, session *navigation.Session)
+
+// MessageHandlerFunc handles a type of message received from the clients.
+type MessageHandlerFunc func(ctx context.Context, client *Client, msg Message)
+
 type Manager struct {
 	clients      map[string]*Client
 	register     chan *Client
 	unregister   chan *Client
 	broadcast    chan Message
+	presence     chan presenceChange
 	mu           sync.RWMutex
 	ctx          context.Context
 	cancel       context.CancelFunc
 	logger       *slog.Logger
 	sessionCache navigation.SessionCache
+	outbox       Outbox
+	cluster      Cluster
+	positions    PositionHandler
+	routeHandler RouteHandler
+	routes       *gis.GridIndex
+	handlers     map[string]MessageHandlerFunc
 }
 
-func NewManager(ctx context.Context, logger *slog.Logger, cache navigation.SessionCache) *Manager {
+func NewManager(ctx context.Context, logger *slog.Logger, cache navigation.SessionCache, outbox Outbox, routes *gis.GridIndex, cluster Cluster) *Manager {
 	ctx, cancel := context.WithCancel(ctx)
 	return &Manager{
 		clients:      make(map[string]*Client),
 		register:     make(chan *Client),
 		unregister:   make(chan *Client),
 		broadcast:    make(chan Message),
+		presence:     make(chan presenceChange, presenceQueueSize),
 		ctx:          ctx,
 		cancel:       cancel,
 		logger:       logger,
 		sessionCache: cache,
+		outbox:       outbox,
+		cluster:      cluster,
+		routes:       routes,
+		handlers:     make(map[string]MessageHandlerFunc),
 	}
 }
 
 func (m *Manager) Start() {
 	defer m.Shutdown()
 	m.logger.Info("Websocket manager is running")
+	// The presence is written to the cluster outside of the event loop, so that a slow Redis doesn't stall it.
+	go m.syncPresence()
+	go m.sweepRoutes()
 	for {
 		select {
 		case client := <-m.register:
 			m.mu.Lock()
+			previous, reconnected := m.clients[client.ID]
 			m.clients[client.ID] = client
 			m.mu.Unlock()
+			if reconnected {
+				// The previous connection of the session is probably dead already, make sure of it.
+				go m.forceDisconnect(previous)
+			} else {
+				metrics.ConnectedClients.Inc()
+			}
+			m.queuePresence(client.ID, true)
 			m.logger.Debug("client connected", "clientID", client.ID)
 		case client := <-m.unregister:
 			m.mu.Lock()
-			if _, ok := m.clients[client.ID]; ok {
+			// The session may have reconnected in the meantime, only unregister the current connection.
+			current, ok := m.clients[client.ID]
+			disconnected := ok && current == client
+			if disconnected {
 				delete(m.clients, client.ID)
 				close(client.send)
-				m.logger.Debug("client disconnected", "clientID", client.ID)
 			}
 			m.mu.Unlock()
+			if disconnected {
+				metrics.ConnectedClients.Dec()
+				m.queuePresence(client.ID, false)
+				m.logger.Debug("client disconnected", "clientID", client.ID)
+			}
 		case message := <-m.broadcast:
 			m.mu.RLock()
 			for _, client := range m.clients {
 				select {
 				case client.send <- message:
 				default:
+					metrics.SendBufferOverflows.Inc()
 					go m.forceDisconnect(client)
 				}
 			}
@@ -68,20 +159,241 @@ func (m *Manager) Start() {
 	}
 }
 
+// queuePresence queues a presence change to write to the cluster.
+// If the queue is full the change is dropped: the presence of the connected sessions is restored by the heartbeats,
+// and the one of the disconnected sessions expires.
+func (m *Manager) queuePresence(sessionID string, connected bool) {
+	select {
+	case m.presence <- presenceChange{sessionID: sessionID, connected: connected}:
+	default:
+		m.logger.Warn("presence queue is full, dropping presence change", "clientID", sessionID, "connected", connected)
+	}
+}
+
+// syncPresence writes the presence changes to the cluster, in order.
+func (m *Manager) syncPresence() {
+	for {
+		select {
+		case change := <-m.presence:
+			if change.connected {
+				if err := m.cluster.Register(m.ctx, change.sessionID); err != nil {
+					m.logger.Warn("failed to register client in cluster", "clientID", change.sessionID, "error", err)
+				}
+			} else if err := m.cluster.Unregister(m.ctx, change.sessionID); err != nil {
+				m.logger.Warn("failed to unregister client from cluster", "clientID", change.sessionID, "error", err)
+			}
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
 func (m *Manager) ClientsUnsafe() map[string]*Client {
 	return m.clients
 }
 
+// SessionsNear returns the sessions whose route may pass within radius metres of the point.
+// Sessions stay indexed while their client is disconnected, so that they can be sent messages to replay.
+func (m *Manager) SessionsNear(point gis.Point, radius float64) []string {
+	return m.routes.Query(point, radius)
+}
+
+// SessionsNearArea returns the sessions whose route may pass within radius metres of the area delimited by the points.
+func (m *Manager) SessionsNearArea(points []gis.Point, radius float64) []string {
+	return m.routes.QueryBounds(points, radius)
+}
+
+// ConnectedSessions returns the sessions whose client is connected to this instance.
+func (m *Manager) ConnectedSessions() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]string, 0, len(m.clients))
+	for id := range m.clients {
+		res = append(res, id)
+	}
+	return res
+}
+
 func (m *Manager) RLock()   { m.mu.RLock() }
 func (m *Manager) RUnlock() { m.mu.RUnlock() }
 
-// HandleNewConnection creates a new client from an accepted connection.
+// IndexRoute registers the session route in the spatial index, replacing the previous one.
+// It must be called every time the route of a session changes.
+func (m *Manager) IndexRoute(session *navigation.Session) {
+	m.routes.Insert(session.ID, session.Route.GISPolyline())
+}
+
+// EnsureRouteIndexed registers the session route in the spatial index if it isn't already,
+// e.g. when the client reconnected to this instance and sends its positions without "init" nor "resume".
+func (m *Manager) EnsureRouteIndexed(session *navigation.Session) {
+	if !m.routes.Contains(session.ID) {
+		m.IndexRoute(session)
+	}
+}
+
+// UnindexRoute removes the session route from the spatial index, once the session is over or expired.
+func (m *Manager) UnindexRoute(sessionID string) {
+	m.routes.Remove(sessionID)
+}
+
+// sweepRoutes periodically removes from the spatial index the routes of the sessions that expired from the cache,
+// the sessions abandoned without arriving would otherwise stay indexed forever.
+func (m *Manager) sweepRoutes() {
+	ticker := time.NewTicker(routesSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if removed := m.unindexExpiredRoutes(); removed > 0 {
+				m.logger.Debug("expired routes removed from the index", "count", removed)
+			}
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// unindexExpiredRoutes removes from the spatial index the routes of the sessions missing from the cache,
+// and returns how many were removed.
+func (m *Manager) unindexExpiredRoutes() int {
+	removed := 0
+	for _, sessionID := range m.routes.IDs() {
+		if _, err := m.sessionCache.GetSession(m.ctx, sessionID); errors.Is(err, navigation.ErrSessionNotFound) {
+			m.UnindexRoute(sessionID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// HandlePositions registers the handler of the position updates.
+// It must be called before the manager is started.
+func (m *Manager) HandlePositions(handler PositionHandler) {
+	m.positions = handler
+}
+
+// HandleRoutes registers the handler of the new routes.
+// It must be called before the manager is started.
+func (m *Manager) HandleRoutes(handler RouteHandler) {
+	m.routeHandler = handler
+}
+
+// RouteChanged notifies the route handler, if any, that the session has a new route.
+// The route must already be indexed.
+func (m *Manager) RouteChanged(ctx context.Context, session *navigation.Session) {
+	if m.routeHandler != nil {
+		m.routeHandler.HandleRoute(ctx, session)
+	}
+}
+
+// HandleMessage registers the handler of a message type, for the messages not handled by the client itself.
+// It must be called before the manager is started.
+func (m *Manager) HandleMessage(msgType string, handler MessageHandlerFunc) {
+	m.handlers[msgType] = handler
+}
+
+var ErrSessionForbidden = errors.New("session belongs to another user")
+
+// Authorize checks that the user can connect to the session: the session doesn't exist yet,
+// or it was created by the same user.
+func (m *Manager) Authorize(ctx context.Context, sessionID string, userID string) error {
+	session, err := m.sessionCache.GetSession(ctx, sessionID)
+	if errors.Is(err, navigation.ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return ErrSessionForbidden
+	}
+	return nil
+}
+
+// HandleNewConnection creates a new client from an accepted connection, authenticated as userID.
 // Can be used in an HTTP handler.
-func (m *Manager) HandleNewConnection(id string, conn *websocket.Conn) {
-	client := NewClient(id, conn, m)
+func (m *Manager) HandleNewConnection(id string, userID string, conn *websocket.Conn) {
+	client := NewClient(id, userID, conn, m)
 	client.Start()
 }
 
+// Send delivers a message to a session.
+// The message is numbered and kept in the session outbox, so that a client disconnected at that time
+// can get it back by resuming its session.
+// If the client is connected to another instance, the message is relayed to it.
+func (m *Manager) Send(ctx context.Context, sessionID string, msg Message) {
+	ctx, span := tracer.Start(ctx, "ws.Send", trace.WithAttributes(
+		attribute.String("session.id", sessionID),
+		attribute.String("message.type", msg.Type),
+	))
+	defer span.End()
+
+	numbered, err := m.outbox.Append(ctx, sessionID, msg)
+	if err != nil {
+		m.logger.Warn("failed to store message in outbox", "clientID", sessionID, "type", msg.Type, "error", err)
+		numbered = msg
+	}
+
+	if m.DeliverLocal(sessionID, numbered) {
+		span.SetAttributes(attribute.String("message.delivery", "local"))
+		return
+	}
+	relayed, err := m.cluster.Relay(ctx, sessionID, numbered)
+	if relayed {
+		span.SetAttributes(attribute.String("message.delivery", "relayed"))
+	}
+	if err != nil {
+		tracing.Fail(span, err)
+		m.logger.Warn("failed to relay message", "clientID", sessionID, "type", msg.Type, "error", err)
+	}
+}
+
+// Dispatch queues a task to run on the session by the read loop of its client,
+// which reads the session from the cache before and saves it after,
+// so that it doesn't race with the updates made on the messages of the client.
+// It returns false if the client isn't connected to this instance.
+func (m *Manager) Dispatch(sessionID string, task SessionTask) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	client, ok := m.clients[sessionID]
+	if !ok {
+		return false
+	}
+	select {
+	case client.tasks <- task:
+	default:
+		m.logger.Warn("session tasks queue is full, dropping task", "clientID", sessionID)
+	}
+	return true
+}
+
+// DeliverLocal sends a message to the client of the session if it is connected to this instance.
+// The message is neither numbered nor stored, see Send.
+// It returns false if the client isn't connected to this instance.
+func (m *Manager) DeliverLocal(sessionID string, msg Message) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	client, ok := m.clients[sessionID]
+	if ok {
+		client.Send(msg)
+	}
+	return ok
+}
+
+// messageTypeLabel returns the metrics label of a received message type.
+// Unknown types are grouped so that clients can't create any number of series.
+func (m *Manager) messageTypeLabel(msgType string) string {
+	switch msgType {
+	case "init", "position", "resume":
+		return msgType
+	}
+	if _, ok := m.handlers[msgType]; ok {
+		return msgType
+	}
+	return "unknown"
+}
+
 func (m *Manager) Broadcast(message Message) {
 	m.broadcast <- message
 }